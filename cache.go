@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	cli "github.com/urfave/cli/v2"
+	gx "github.com/whyrusleeping/gx/gxutil"
+	. "github.com/whyrusleeping/stump"
+)
+
+var CacheCommand = cli.Command{
+	Name:  "cache",
+	Usage: "manage cached repo resolutions",
+	Description: `gx caches the resolution of ipns repo paths in $XDG_CACHE_HOME/gx
+   (~/.cache/gx by default). Cached entries expire after the ttl set by the
+   'cache.ttl' option in .gxrc or the GX_CACHE_TTL environment variable,
+   one hour by default. Expired entries are still used if the name cannot
+   be resolved.
+`,
+	Subcommands: []*cli.Command{
+		&cacheLsCommand,
+		&cacheClearCommand,
+	},
+}
+
+var cacheLsCommand = cli.Command{
+	Name:  "ls",
+	Usage: "list cached resolutions",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print cache entries as json",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		cache, err := gx.LoadCache()
+		if err != nil {
+			return err
+		}
+
+		if c.Bool("json") {
+			jsonPrint(cache)
+			return nil
+		}
+
+		var names []string
+		for n := range cache {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+
+		ttl := pm.CacheTTL()
+		w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVALUE\tAGE\tSTATUS")
+		for _, n := range names {
+			e := cache[n]
+
+			age := "unknown"
+			if !e.Time.IsZero() {
+				age = time.Since(e.Time).Round(time.Second).String()
+			}
+
+			status := "valid"
+			if e.Expired(ttl) {
+				status = "expired"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", n, e.Value, age, status)
+		}
+		return w.Flush()
+	},
+}
+
+var cacheClearCommand = cli.Command{
+	Name:      "clear",
+	Usage:     "clear cached resolutions",
+	ArgsUsage: "[name...]",
+	Description: `Remove the given names from the cache. With no arguments, clear all
+   cached resolutions along with the repo search index and the ~/.gxcache
+   of older versions of gx.
+`,
+	Action: func(c *cli.Context) error {
+		n, err := gx.ClearCache(c.Args().Slice()...)
+		if err != nil {
+			return err
+		}
+
+		VLog("removed %d cache entries", n)
+		return nil
+	},
+}
@@ -21,25 +21,25 @@ func check(pkg *gx.Package) (bool, error) {
 
 	var traverse func(*gx.Package) error
 	traverse = func(pkg *gx.Package) error {
-		return pkg.ForEachDep(func(dep *gx.Dependency, dpkg *gx.Package) error {
+		return pkg.ForEachDep(func(dep *gx.Dependency, hash string, dpkg *gx.Package) error {
 			pkgVersions, ok := packages[dpkg.Name]
 			if !ok {
 				pkgVersions = make(map[string]*pkgImport, 1)
 				packages[dpkg.Name] = pkgVersions
 			}
-			imp, ok := pkgVersions[dep.Hash]
+			imp, ok := pkgVersions[hash]
 			if !ok {
 				version, err := semver.Parse(dpkg.Version)
 				if dpkg.Version != "" && err != nil {
 					fmt.Printf(
 						"package %s (%s) has an invalid version '%s': %s\n",
 						dpkg.Name,
-						dep.Hash,
+						hash,
 						dpkg.Version,
 						err,
 					)
 				}
-				pkgVersions[dep.Hash] = &pkgImport{
+				pkgVersions[hash] = &pkgImport{
 					version: version,
 					parents: []string{pkg.Name},
 				}
@@ -102,7 +102,7 @@ func check(pkg *gx.Package) (bool, error) {
 	}
 
 	// Finally, check names and versions.
-	if err := pkg.ForEachDep(func(dep *gx.Dependency, dpkg *gx.Package) error {
+	if err := pkg.ForEachDep(func(dep *gx.Dependency, hash string, dpkg *gx.Package) error {
 		if dep.Name != dpkg.Name {
 			failed = true
 			fmt.Printf(
@@ -111,7 +111,8 @@ func check(pkg *gx.Package) (bool, error) {
 				dpkg.Name,
 			)
 		}
-		if dep.Version != dpkg.Version {
+		// an overridden dependency is expected to differ from its entry
+		if hash == dep.Hash && dep.Version != dpkg.Version {
 			failed = true
 			fmt.Printf(
 				"dependency %s has version %s but the referenced package has version %s\n",
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	cli "github.com/urfave/cli/v2"
+	gx "github.com/whyrusleeping/gx/gxutil"
+	filter "github.com/whyrusleeping/json-filter"
+	. "github.com/whyrusleeping/stump"
+)
+
+var ConfigCommand = cli.Command{
+	Name:  "config",
+	Usage: "read and write gx configuration",
+	Description: `config reads and writes the .gxrc files gx is configured by, using
+   the same query syntax as 'gx view' and 'gx set'. Values are read from the
+   merged configuration, and written to the .gxrc of the current directory,
+   unless --global is passed to use ~/.gxrc only.
+
+   The configuration is merged from, lowest precedence first: /etc/gxrc,
+   $XDG_CONFIG_HOME/gx/gxrc, ~/.gxrc, the .gxrc of every directory from the
+   package root down to the current directory, and the GX_STORE,
+   GX_STORE_PATH, GX_CACHE_TTL, GX_CHECKOUTS, GX_USER_NAME and GX_USER_EMAIL
+   environment variables.
+
+EXAMPLE:
+   > gx config set --global user.name whyrusleeping
+   > gx config get user
+   {
+     "name": "whyrusleeping"
+   }
+
+   > gx config set --in-json repo_priorities.myrepo 500
+   > gx config unset repo_priorities.myrepo
+`,
+	Subcommands: []*cli.Command{
+		&configGetCommand,
+		&configSetCommand,
+		&configUnsetCommand,
+		&configListCommand,
+		&configCheckCommand,
+	},
+}
+
+var configGlobalFlag = &cli.BoolFlag{
+	Name:  "global",
+	Usage: "use the global ~/.gxrc only",
+}
+
+// configSources returns the config sources to read values from
+func configSources(global bool) ([]*gx.ConfigSource, error) {
+	if !global {
+		return gx.LoadConfigSources()
+	}
+
+	p, err := cfgPath(true)
+	if err != nil {
+		return nil, err
+	}
+
+	vals, err := gx.LoadConfigFile(p)
+	if err != nil {
+		return nil, err
+	}
+	return []*gx.ConfigSource{{Name: p, Values: vals}}, nil
+}
+
+var configGetCommand = cli.Command{
+	Name:      "get",
+	Usage:     "print a config value",
+	ArgsUsage: "<query>",
+	Flags:     []cli.Flag{configGlobalFlag},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("must specify a query")
+		}
+
+		srcs, err := configSources(c.Bool("global"))
+		if err != nil {
+			return err
+		}
+
+		val, err := filter.Get(gx.MergeConfigSources(srcs), c.Args().First())
+		if err != nil {
+			return err
+		}
+
+		jsonPrint(val)
+		return nil
+	},
+}
+
+var configSetCommand = cli.Command{
+	Name:      "set",
+	Usage:     "set a config value",
+	ArgsUsage: "<query> <value>",
+	Flags: []cli.Flag{
+		configGlobalFlag,
+		&cli.BoolFlag{
+			Name:  "in-json",
+			Usage: "Interpret input as json",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 2 {
+			return fmt.Errorf("must specify query and value")
+		}
+
+		cfp, err := cfgPath(c.Bool("global"))
+		if err != nil {
+			return err
+		}
+
+		cfg, err := gx.LoadConfigFile(cfp)
+		if err != nil {
+			return err
+		}
+
+		queryStr := c.Args().Get(0)
+		valueStr := c.Args().Get(1)
+		key := strings.TrimLeft(queryStr, ".")
+		var value interface{}
+		if c.Bool("in-json") {
+			if err := json.Unmarshal([]byte(valueStr), &value); err != nil {
+				return err
+			}
+		} else {
+			value = gx.ParseConfigValue(key, valueStr)
+		}
+
+		makeConfigParents(cfg, queryStr)
+		if err := filter.Set(cfg, queryStr, value); err != nil {
+			return err
+		}
+
+		// refuse typos, but leave other unknown keys alone
+		for _, ce := range gx.ValidateConfig(&gx.ConfigSource{Name: cfp, Values: cfg}) {
+			if ce.Unknown && (ce.Key == key || strings.HasPrefix(key, ce.Key+".")) {
+				return fmt.Errorf("%s is not a config option", ce.Key)
+			}
+		}
+
+		return gx.SaveConfigFile(cfg, cfp)
+	},
+}
+
+var configUnsetCommand = cli.Command{
+	Name:      "unset",
+	Usage:     "remove a config value",
+	ArgsUsage: "<query>",
+	Flags:     []cli.Flag{configGlobalFlag},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("must specify a query")
+		}
+
+		cfp, err := cfgPath(c.Bool("global"))
+		if err != nil {
+			return err
+		}
+
+		cfg, err := gx.LoadConfigFile(cfp)
+		if err != nil {
+			return err
+		}
+
+		if err := unsetConfigKey(cfg, c.Args().First()); err != nil {
+			return fmt.Errorf("%s in %s", err, cfp)
+		}
+
+		return gx.SaveConfigFile(cfg, cfp)
+	},
+}
+
+var configListCommand = cli.Command{
+	Name:  "list",
+	Usage: "list config values and the files they come from",
+	Flags: []cli.Flag{
+		configGlobalFlag,
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print config values as json",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		srcs, err := configSources(c.Bool("global"))
+		if err != nil {
+			return err
+		}
+
+		vals := gx.ConfigValues(srcs)
+
+		if c.Bool("json") {
+			jsonPrint(vals)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, v := range vals {
+			val, ok := v.Value.(string)
+			if !ok {
+				out, err := json.Marshal(v.Value)
+				if err != nil {
+					return err
+				}
+				val = string(out)
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, val, v.Source)
+		}
+		return w.Flush()
+	},
+}
+
+var configCheckCommand = cli.Command{
+	Name:  "check",
+	Usage: "check config files for unknown options and invalid values",
+	Flags: []cli.Flag{configGlobalFlag},
+	Action: func(c *cli.Context) error {
+		srcs, err := configSources(c.Bool("global"))
+		if err != nil {
+			return err
+		}
+
+		var n int
+		for _, src := range srcs {
+			for _, ce := range gx.ValidateConfig(src) {
+				if ce.Unknown {
+					Log("warning: %s", ce)
+				} else {
+					Error(ce)
+				}
+				n++
+			}
+		}
+
+		if n > 0 {
+			return fmt.Errorf("found %d problems in config", n)
+		}
+
+		VLog("config ok")
+		return nil
+	},
+}
+
+// makeConfigParents creates the objects on the way to the value set by
+// query, which filter.Set expects to exist
+func makeConfigParents(cfg map[string]interface{}, query string) {
+	parts := strings.Split(strings.TrimLeft(query, "."), ".")
+	cur := cfg
+	for _, p := range parts[:len(parts)-1] {
+		if strings.Contains(p, "[") {
+			return
+		}
+
+		next, ok := cur[p].(map[string]interface{})
+		if !ok {
+			if _, exists := cur[p]; exists {
+				return
+			}
+
+			next = make(map[string]interface{})
+			cur[p] = next
+		}
+		cur = next
+	}
+}
+
+func unsetConfigKey(cfg map[string]interface{}, query string) error {
+	query = strings.TrimLeft(query, ".")
+	parent, key := "", query
+	if i := strings.LastIndex(query, "."); i >= 0 {
+		parent, key = query[:i], query[i+1:]
+	}
+
+	if key == "" || strings.ContainsAny(key, "[]") {
+		return fmt.Errorf("can only unset object keys, not %q", query)
+	}
+
+	var obj interface{} = cfg
+	if parent != "" {
+		v, err := filter.Get(cfg, parent)
+		if err != nil {
+			return err
+		}
+		obj = v
+	}
+
+	m, ok := obj.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s is not an object", parent)
+	}
+
+	if _, ok := m[key]; !ok {
+		return fmt.Errorf("key not found: %s", query)
+	}
+
+	delete(m, key)
+
+	// don't leave empty objects behind
+	if len(m) == 0 && parent != "" && !strings.ContainsAny(parent, "[]") {
+		return unsetConfigKey(cfg, parent)
+	}
+	return nil
+}
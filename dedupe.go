@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+	log "github.com/whyrusleeping/stump"
+
+	"github.com/blang/semver"
+)
+
+type dedupeImport struct {
+	dep     *gx.Dependency
+	hash    string
+	pkg     *gx.Package
+	version semver.Version
+	parents []string
+}
+
+// dedupePlan describes how to get the dependency tree down to a single
+// hash per package name
+type dedupePlan struct {
+	// name -> hash kept for that name
+	keep map[string]string
+
+	// hash -> hash it is replaced with
+	replace map[string]string
+
+	// packages that have to be republished to point at the kept hashes
+	republish []*dedupeImport
+
+	imports map[string]map[string]*dedupeImport
+}
+
+// planDedupe works out which hash to keep for every package imported under
+// multiple hashes. By default the highest version is kept, picks maps
+// package names to a hash or version to keep instead.
+func planDedupe(pkg *gx.Package, picks map[string]string) (*dedupePlan, error) {
+	// name -> hash -> import
+	imports := make(map[string]map[string]*dedupeImport)
+	byHash := make(map[string]*dedupeImport)
+
+	var traverse func(*gx.Package) error
+	traverse = func(pkg *gx.Package) error {
+		return pkg.ForEachDep(func(dep *gx.Dependency, hash string, dpkg *gx.Package) error {
+			if imports[dpkg.Name] == nil {
+				imports[dpkg.Name] = make(map[string]*dedupeImport)
+			}
+
+			imp, ok := imports[dpkg.Name][hash]
+			if ok {
+				imp.parents = append(imp.parents, pkg.Name)
+				return nil
+			}
+
+			version, err := semver.Parse(dpkg.Version)
+			if dpkg.Version != "" && err != nil {
+				log.Log("package %s (%s) has an invalid version '%s': %s", dpkg.Name, hash, dpkg.Version, err)
+			}
+
+			imp = &dedupeImport{
+				dep:     dep,
+				hash:    hash,
+				pkg:     dpkg,
+				version: version,
+				parents: []string{pkg.Name},
+			}
+			imports[dpkg.Name][hash] = imp
+			byHash[hash] = imp
+
+			return traverse(dpkg)
+		})
+	}
+
+	if err := traverse(pkg); err != nil {
+		return nil, err
+	}
+
+	plan := &dedupePlan{
+		keep:    make(map[string]string),
+		replace: make(map[string]string),
+		imports: imports,
+	}
+
+	for name := range picks {
+		if _, ok := imports[name]; !ok {
+			return nil, fmt.Errorf("package %s is not in the dependency tree", name)
+		}
+	}
+
+	for name, hashes := range imports {
+		if len(hashes) < 2 {
+			continue
+		}
+
+		keep, err := pickDedupeHash(name, hashes, picks[name])
+		if err != nil {
+			return nil, err
+		}
+
+		plan.keep[name] = keep
+		for h := range hashes {
+			if h != keep {
+				plan.replace[h] = keep
+			}
+		}
+	}
+
+	// a package has to be republished if any of its deps, after replacing
+	// dupes, is replaced or republished itself. Overrides of the root apply
+	// to the whole tree, the same way they did while traversing it.
+	memo := make(map[string]bool)
+	var needsRepublish func(hash string) bool
+	needsRepublish = func(hash string) bool {
+		if v, ok := memo[hash]; ok {
+			return v
+		}
+
+		imp, ok := byHash[hash]
+		if !ok {
+			return false
+		}
+
+		var out bool
+		for _, d := range imp.pkg.Dependencies {
+			h := pkg.Overrides.Apply(d).Hash
+
+			// overridden deps are updated through the overrides of the
+			// root, which leaves this package as it is
+			overridden := h != d.Hash
+			if to, ok := plan.replace[h]; ok {
+				out = out || !overridden
+				h = to
+			}
+
+			if needsRepublish(h) && !overridden {
+				out = true
+			}
+		}
+
+		memo[hash] = out
+		if out {
+			plan.republish = append(plan.republish, imp)
+		}
+		return out
+	}
+
+	for _, d := range pkg.Dependencies {
+		hash := pkg.Overrides.Apply(d).Hash
+		if to, ok := plan.replace[hash]; ok {
+			hash = to
+		}
+		needsRepublish(hash)
+	}
+
+	sort.Slice(plan.republish, func(i, j int) bool {
+		return plan.republish[i].pkg.Name < plan.republish[j].pkg.Name
+	})
+
+	return plan, nil
+}
+
+func pickDedupeHash(name string, hashes map[string]*dedupeImport, pick string) (string, error) {
+	if pick != "" {
+		var found []string
+		for h, imp := range hashes {
+			if h == pick || imp.pkg.Version == pick {
+				found = append(found, h)
+			}
+		}
+
+		switch len(found) {
+		case 0:
+			return "", fmt.Errorf("no import of %s matches %s", name, pick)
+		case 1:
+			return found[0], nil
+		default:
+			return "", fmt.Errorf("%s matches multiple imports of %s, pick one by hash", pick, name)
+		}
+	}
+
+	var best string
+	for h, imp := range hashes {
+		if best == "" {
+			best = h
+			continue
+		}
+
+		switch imp.version.Compare(hashes[best].version) {
+		case 1:
+			best = h
+		case 0:
+			// prefer the hash most of the tree already uses
+			if len(imp.parents) > len(hashes[best].parents) ||
+				(len(imp.parents) == len(hashes[best].parents) && h < best) {
+				best = h
+			}
+		}
+	}
+	return best, nil
+}
+
+func (p *dedupePlan) print() {
+	var names []string
+	for name := range p.keep {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		keep := p.imports[name][p.keep[name]]
+		fmt.Printf("package %s: keeping %s %s\n", name, keep.pkg.Version, keep.hash)
+
+		var hashes []string
+		for h := range p.imports[name] {
+			if h != p.keep[name] {
+				hashes = append(hashes, h)
+			}
+		}
+		sort.Strings(hashes)
+
+		for _, h := range hashes {
+			imp := p.imports[name][h]
+			sort.Strings(imp.parents)
+			fmt.Printf("  - replacing %s %s (imported by %s)\n", imp.pkg.Version, h, strings.Join(imp.parents, ", "))
+		}
+	}
+
+	if len(p.republish) > 0 {
+		fmt.Println("packages to republish:")
+		for _, imp := range p.republish {
+			fmt.Printf("  - %s %s\n", imp.pkg.Name, imp.hash)
+		}
+	}
+}
+
+// runDedupe rewrites the dependency tree of pkg according to plan,
+// republishing packages as needed, and saves the result to package.json.
+// Republished packages are installed to ipath.
+func runDedupe(pkg *gx.Package, plan *dedupePlan, ipath string) error {
+	old := make(map[string]string)
+	for _, d := range pkg.Dependencies {
+		old[d.Name] = d.Hash
+	}
+
+	updates := make(map[string]string)
+	for from, to := range plan.replace {
+		updates[from] = to
+	}
+
+	overrides := make(map[string]string)
+	for k, v := range pkg.Overrides {
+		overrides[k] = v
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	// kept packages may get republished themselves, so keep going until
+	// nothing points at a replaced hash anymore
+	for {
+		changed, err := cascadingUpdate(pkg, dir, updates, make(map[string]bool))
+		if err != nil {
+			return err
+		}
+
+		// overrides may have been retargeted anywhere in the tree
+		for k, v := range pkg.Overrides {
+			if overrides[k] != v {
+				overrides[k] = v
+				changed = true
+			}
+		}
+
+		if !changed {
+			break
+		}
+
+		err = pm.InstallDeps(pkg, ipath)
+		if err != nil {
+			return fmt.Errorf("installing updated deps: %s", err)
+		}
+	}
+
+	err = gx.SavePackageFile(pkg, PkgFileName)
+	if err != nil {
+		return fmt.Errorf("writing package file: %s", err)
+	}
+
+	for _, d := range pkg.Dependencies {
+		if old[d.Name] == d.Hash {
+			continue
+		}
+
+		log.VLog("running post update hook for %s...", d.Name)
+		err := gx.TryRunHook("post-update", pkg.Language, pkg.SubtoolRequired, old[d.Name], d.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
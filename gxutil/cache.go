@@ -0,0 +1,324 @@
+package gxutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	hd "github.com/mitchellh/go-homedir"
+	. "github.com/whyrusleeping/stump"
+)
+
+// CacheFileName is the name of the file, in the cache directory, holding
+// cached resolutions of repo names
+const CacheFileName = "resolve.json"
+
+// DefaultCacheTTL is how long a cached resolution is used before the name is
+// resolved again
+const DefaultCacheTTL = time.Hour
+
+const (
+	// how long to wait for another gx process to release the cache
+	cacheLockTimeout = 10 * time.Second
+
+	// locks older than this were left behind by a crashed process
+	cacheLockStale = time.Minute
+)
+
+// CacheEntry is a cached resolution of a name
+type CacheEntry struct {
+	Value string    `json:"value"`
+	Time  time.Time `json:"time"`
+}
+
+// Expired reports whether the entry is older than ttl
+func (ce *CacheEntry) Expired(ttl time.Duration) bool {
+	return time.Since(ce.Time) > ttl
+}
+
+type cacheFile struct {
+	Entries map[string]*CacheEntry `json:"entries"`
+}
+
+// CacheDir returns the directory gx keeps its caches in, $XDG_CACHE_HOME/gx
+// or ~/.cache/gx
+func CacheDir() (string, error) {
+	if d := os.Getenv("XDG_CACHE_HOME"); d != "" {
+		return filepath.Join(d, "gx"), nil
+	}
+
+	home, err := hd.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".cache", "gx"), nil
+}
+
+func cachePath(name string) (string, error) {
+	dir, err := CacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// CacheTTL returns how long cached resolutions are valid, as set by the
+// cache.ttl config option or GX_CACHE_TTL, either as a duration or as a number
+// of seconds
+func (pm *PM) CacheTTL() time.Duration {
+	var v string
+	if pm.cfg != nil {
+		v = pm.cfg.Cache.TTL
+	}
+
+	if v == "" {
+		return DefaultCacheTTL
+	}
+
+	if secs, err := strconv.Atoi(v); err == nil {
+		return time.Duration(secs) * time.Second
+	}
+
+	ttl, err := time.ParseDuration(v)
+	if err != nil {
+		Error("invalid cache ttl %q, using %s: %s", v, DefaultCacheTTL, err)
+		return DefaultCacheTTL
+	}
+	return ttl
+}
+
+// LoadCache returns all cached resolutions
+func LoadCache() (map[string]*CacheEntry, error) {
+	p, err := cachePath(CacheFileName)
+	if err != nil {
+		return nil, err
+	}
+
+	return readCache(p)
+}
+
+func readCache(p string) (map[string]*CacheEntry, error) {
+	data, err := ioutil.ReadFile(p)
+	switch {
+	case os.IsNotExist(err):
+		return readLegacyCache()
+	case err != nil:
+		return nil, err
+	}
+
+	var cf cacheFile
+	if err := json.Unmarshal(data, &cf); err != nil {
+		return nil, fmt.Errorf("parsing cache %s: %s", p, err)
+	}
+
+	if cf.Entries == nil {
+		cf.Entries = make(map[string]*CacheEntry)
+	}
+	return cf.Entries, nil
+}
+
+// legacyCachePath returns the path of the ~/.gxcache of older versions of gx
+func legacyCachePath() (string, error) {
+	home, err := hd.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".gxcache"), nil
+}
+
+// readLegacyCache reads the ~/.gxcache of older versions of gx. Its entries
+// have no timestamp and are treated as expired.
+func readLegacyCache() (map[string]*CacheEntry, error) {
+	out := make(map[string]*CacheEntry)
+
+	p, err := legacyCachePath()
+	if err != nil {
+		return out, nil
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		return out, nil
+	}
+
+	var legacy map[string]string
+	if err := json.Unmarshal(data, &legacy); err != nil {
+		VLog("ignoring unreadable ~/.gxcache: %s", err)
+		return out, nil
+	}
+
+	for k, v := range legacy {
+		out[k] = &CacheEntry{Value: v}
+	}
+	return out, nil
+}
+
+// updateCache applies fn to the cached resolutions while holding the cache
+// lock, and writes back the result
+func updateCache(fn func(map[string]*CacheEntry)) error {
+	p, err := cachePath(CacheFileName)
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockFile(p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	entries, err := readCache(p)
+	if err != nil {
+		return err
+	}
+
+	fn(entries)
+
+	data, err := json.Marshal(&cacheFile{Entries: entries})
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(p, data)
+}
+
+// CheckCacheFile returns the cached resolution of name, however old it is
+func CheckCacheFile(name string) (string, bool, error) {
+	cache, err := LoadCache()
+	if err != nil {
+		return "", false, err
+	}
+
+	e, ok := cache[name]
+	if !ok {
+		return "", false, nil
+	}
+	return e.Value, true, nil
+}
+
+func (pm *PM) cacheSet(name, resolved string) error {
+	return updateCache(func(cache map[string]*CacheEntry) {
+		cache[name] = &CacheEntry{
+			Value: resolved,
+			Time:  time.Now().UTC(),
+		}
+	})
+}
+
+// ClearCache removes the given names from the resolution cache, reporting
+// how many were cached. With no names, all of the caches of gx are removed,
+// including the ~/.gxcache of older versions, which would otherwise be read
+// again in place of the removed cache.
+func ClearCache(names ...string) (int, error) {
+	if len(names) == 0 {
+		p, err := cachePath(CacheFileName)
+		if err != nil {
+			return 0, err
+		}
+
+		// hold the lock, so that no write of another gx is lost halfway
+		// or brings back what was removed
+		unlock, err := lockFile(p)
+		if err != nil {
+			return 0, err
+		}
+		defer unlock()
+
+		cache, err := readCache(p)
+		if err != nil {
+			return 0, err
+		}
+
+		paths := []string{p}
+		sp, err := cachePath(SearchIndexFile)
+		if err != nil {
+			return 0, err
+		}
+		paths = append(paths, sp)
+		if p, err := legacyCachePath(); err == nil {
+			paths = append(paths, p)
+		}
+
+		for _, p := range paths {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				return 0, err
+			}
+		}
+		return len(cache), nil
+	}
+
+	var n int
+	err := updateCache(func(cache map[string]*CacheEntry) {
+		for _, name := range names {
+			if _, ok := cache[name]; ok {
+				delete(cache, name)
+				n++
+			}
+		}
+	})
+	return n, err
+}
+
+// lockFile takes an exclusive lock on p by creating p.lock, waiting for
+// other processes holding it. It returns a function releasing the lock.
+func lockFile(p string) (func(), error) {
+	lp := p + ".lock"
+	deadline := time.Now().Add(cacheLockTimeout)
+	for {
+		fi, err := os.OpenFile(lp, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fi.Close()
+			return func() { os.Remove(lp) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, err
+		}
+
+		if st, err := os.Stat(lp); err == nil && time.Since(st.ModTime()) > cacheLockStale {
+			VLog("removing stale lock %s", lp)
+			os.Remove(lp)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out waiting for lock on %s, remove %s if no other gx is running", p, lp)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+// writeFileAtomic replaces the contents of p, so that readers never see a
+// partially written file
+func writeFileAtomic(p string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(p), filepath.Base(p)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
@@ -0,0 +1,83 @@
+package gxutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+func TestClearCacheLocks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// clearing removes the legacy cache in the home directory too
+	for k, v := range map[string]string{"XDG_CACHE_HOME": dir, "HOME": dir} {
+		defer os.Setenv(k, os.Getenv(k))
+		os.Setenv(k, v)
+	}
+	homedir.DisableCache = true
+	defer func() { homedir.DisableCache = false }()
+
+	pm := &PM{cfg: new(Config)}
+	if err := pm.cacheSet("/ipns/a", "/ipfs/QmA"); err != nil {
+		t.Fatal(err)
+	}
+
+	p := filepath.Join(dir, "gx", CacheFileName)
+	unlock, err := lockFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		n, err := ClearCache()
+		if err == nil && n != 1 {
+			t.Errorf("expected 1 cleared entry, got %d", n)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("cache cleared while locked: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := os.Stat(p); err != nil {
+		t.Fatalf("cache removed while locked: %s", err)
+	}
+
+	unlock()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(p); !os.IsNotExist(err) {
+		t.Fatalf("cache not removed: %v", err)
+	}
+	if _, err := os.Stat(p + ".lock"); !os.IsNotExist(err) {
+		t.Fatalf("lock not released: %v", err)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	cases := map[string]time.Duration{
+		"":     DefaultCacheTTL,
+		"12h":  12 * time.Hour,
+		"3600": time.Hour,
+		"soon": DefaultCacheTTL,
+	}
+
+	for v, expected := range cases {
+		pm := &PM{cfg: &Config{Cache: CacheConfig{TTL: v}}}
+		if ttl := pm.CacheTTL(); ttl != expected {
+			t.Errorf("%q: expected %s, got %s", v, expected, ttl)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package gxutil
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	hd "github.com/mitchellh/go-homedir"
+	. "github.com/whyrusleeping/stump"
+)
+
+// CheckoutConfig configures reading .gx/lastpubver from local git checkouts
+// of forge repositories, so forge references resolve without network access
+type CheckoutConfig struct {
+	// Enabled turns the lookup on. Setting GX_CHECKOUTS in the environment
+	// overrides it, see LoadConfig.
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Paths are searched for checkouts at <path>/<host>/<repo>. Defaults to
+	// the src directories of $GOPATH.
+	Paths []string `json:"paths,omitempty"`
+
+	// Ref is the git ref read when a reference names no branch or tag,
+	// HEAD by default
+	Ref string `json:"ref,omitempty"`
+}
+
+func (pm *PM) checkoutsEnabled() bool {
+	return pm.cfg != nil && pm.cfg.Checkouts.Enabled
+}
+
+func (pm *PM) checkoutPaths() []string {
+	if pm.cfg != nil && len(pm.cfg.Checkouts.Paths) > 0 {
+		var out []string
+		for _, p := range pm.cfg.Checkouts.Paths {
+			if ep, err := hd.Expand(p); err == nil {
+				out = append(out, ep)
+			}
+		}
+		return out
+	}
+
+	gopath := os.Getenv("GOPATH")
+	if gopath == "" {
+		home, err := hd.Dir()
+		if err != nil {
+			return nil
+		}
+		gopath = filepath.Join(home, "go")
+	}
+
+	var out []string
+	for _, p := range filepath.SplitList(gopath) {
+		out = append(out, filepath.Join(p, "src"))
+	}
+	return out
+}
+
+// findCheckout returns the directory of a local git checkout of the given
+// forge repository, if lookups are enabled and one exists
+func (pm *PM) findCheckout(host, repo string) string {
+	if !pm.checkoutsEnabled() {
+		return ""
+	}
+
+	for _, p := range pm.checkoutPaths() {
+		dir := filepath.Join(p, host, filepath.FromSlash(repo))
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return dir
+		}
+	}
+	return ""
+}
+
+func (pm *PM) checkoutDefaultRef() string {
+	if pm.cfg != nil && pm.cfg.Checkouts.Ref != "" {
+		return pm.cfg.Checkouts.Ref
+	}
+	return "HEAD"
+}
+
+// checkoutLastPubVer reads .gx/lastpubver at the given ref of a checkout,
+// trying the remote branch of that name too. It returns an empty hash if the
+// file does not exist at any of them.
+func checkoutLastPubVer(dir, ref string) (string, string) {
+	refs := []string{ref}
+	if ref != "HEAD" && !strings.HasPrefix(ref, "origin/") {
+		refs = append(refs, "origin/"+ref)
+	}
+
+	for _, r := range refs {
+		out, err := git(dir, "show", r+":.gx/lastpubver")
+		if err != nil {
+			VLog("  - no .gx/lastpubver at %s in %s: %s", r, dir, err)
+			continue
+		}
+
+		parts := strings.Split(out, ": ")
+		if len(parts) < 2 {
+			VLog("  - unrecognized format on .gx/lastpubver at %s in %s", r, dir)
+			continue
+		}
+
+		VLog("  - read .gx/lastpubver at %s in %s", r, dir)
+		return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+	}
+
+	return "", ""
+}
+
+// checkoutTags returns the tags of a checkout
+func checkoutTags(dir string) ([]string, error) {
+	out, err := git(dir, "tag", "-l")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	stderr := new(bytes.Buffer)
+	cmd.Stderr = stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", errors.New(msg)
+		}
+		return "", err
+	}
+	return string(out), nil
+}
@@ -5,25 +5,54 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 
 	homedir "github.com/mitchellh/go-homedir"
-	"path/filepath"
 )
 
 const CfgFileName = ".gxrc"
 
 type Config struct {
-	Repos      map[string]string `json:"repos,omitempty"`
-	ExtraRepos map[string]string `json:"extra_repos,omitempty"`
-	User       User              `json:"user,omitempty"`
+	Repos          map[string]string `json:"repos,omitempty"`
+	ExtraRepos     map[string]string `json:"extra_repos,omitempty"`
+	CommunityRepos map[string]string `json:"community_repos,omitempty"`
+
+	// RepoPriorities overrides the priority of repos by name, see
+	// OrderedRepos
+	RepoPriorities map[string]int `json:"repo_priorities,omitempty"`
+
+	User  User        `json:"user,omitempty"`
+	Store StoreConfig `json:"store,omitempty"`
+	Cache CacheConfig `json:"cache,omitempty"`
+
+	// Resolvers maps host patterns to the code forges hosting packages
+	// there, see ParseForgeRef
+	Resolvers map[string]*ForgeConfig `json:"resolvers,omitempty"`
+
+	Checkouts CheckoutConfig `json:"checkouts,omitempty"`
+
+	// NoVerify turns off checking fetched content against its hash
+	NoVerify bool `json:"no_verify,omitempty"`
+
+	// AllowDepHooks lets dependencies run the gxHooks scripts of their
+	// package.json when they are installed or imported
+	AllowDepHooks bool `json:"allow_dep_hooks,omitempty"`
 }
 
 func (c *Config) GetRepos() map[string]string {
-	if len(c.ExtraRepos) == 0 {
+	if len(c.ExtraRepos) == 0 && len(c.CommunityRepos) == 0 {
 		return c.Repos
 	}
 
 	combined := make(map[string]string)
+	for k, v := range c.CommunityRepos {
+		combined[k] = v
+	}
+
 	for k, v := range c.Repos {
 		combined[k] = v
 	}
@@ -35,48 +64,291 @@ func (c *Config) GetRepos() map[string]string {
 	return combined
 }
 
+// Repo tiers. Local repos are the extra repos of a project, global repos are
+// the user's own and community repos are shared third party ones.
+const (
+	RepoTierLocal     = "local"
+	RepoTierGlobal    = "global"
+	RepoTierCommunity = "community"
+)
+
+var repoTierPriority = map[string]int{
+	RepoTierLocal:     300,
+	RepoTierGlobal:    200,
+	RepoTierCommunity: 100,
+}
+
+// RepoRef is a configured repo
+type RepoRef struct {
+	Name     string `json:"name"`
+	Path     string `json:"path"`
+	Tier     string `json:"tier"`
+	Priority int    `json:"priority"`
+
+	// Explicit is set if the priority comes from repo_priorities rather
+	// than the tier of the repo
+	Explicit bool `json:"explicit,omitempty"`
+}
+
+// OrderedRepos returns the configured repos in the order names are resolved
+// in: highest priority first, then by tier and by name. Unless overridden in
+// repo_priorities, local repos come before global repos, which come before
+// community repos.
+func (c *Config) OrderedRepos() []*RepoRef {
+	byName := make(map[string]*RepoRef)
+	add := func(repos map[string]string, tier string) {
+		for name, p := range repos {
+			byName[name] = &RepoRef{
+				Name:     name,
+				Path:     p,
+				Tier:     tier,
+				Priority: repoTierPriority[tier],
+			}
+		}
+	}
+
+	// later tiers shadow earlier ones of the same name
+	add(c.CommunityRepos, RepoTierCommunity)
+	add(c.Repos, RepoTierGlobal)
+	add(c.ExtraRepos, RepoTierLocal)
+
+	out := make([]*RepoRef, 0, len(byName))
+	for _, r := range byName {
+		if prio, ok := c.RepoPriorities[r.Name]; ok {
+			r.Priority = prio
+			r.Explicit = true
+		}
+		out = append(out, r)
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		a, b := out[i], out[j]
+		switch {
+		case a.Priority != b.Priority:
+			return a.Priority > b.Priority
+		case a.Tier != b.Tier:
+			return repoTierPriority[a.Tier] > repoTierPriority[b.Tier]
+		default:
+			return a.Name < b.Name
+		}
+	})
+	return out
+}
+
 type User struct {
 	Name  string `json:"name,omitempty"`
 	Email string `json:"email,omitempty"`
 }
 
+// StoreConfig selects the ContentStore gx uses, see NewContentStore
+type StoreConfig struct {
+	Type string `json:"type,omitempty"`
+	Path string `json:"path,omitempty"`
+}
+
+// CacheConfig controls the resolution cache, see CacheTTL
+type CacheConfig struct {
+	// TTL is a duration such as "30m" or "12h", or a number of seconds
+	TTL string `json:"ttl,omitempty"`
+}
+
+// SystemConfigPath is the config file shared by all users of the system
+var SystemConfigPath = "/etc/gxrc"
+
+// ConfigSource is a layer of configuration, read from a config file or an
+// environment variable
+type ConfigSource struct {
+	Name   string
+	Values map[string]interface{}
+}
+
+// configEnv lists the environment variables overriding config values, and
+// the keys they set
+var configEnv = []struct {
+	Var string
+	Key string
+}{
+	{"GX_STORE", "store.type"},
+	{"GX_STORE_PATH", "store.path"},
+	{"GX_CACHE_TTL", "cache.ttl"},
+	{"GX_CHECKOUTS", "checkouts.enabled"},
+	{"GX_USER_NAME", "user.name"},
+	{"GX_USER_EMAIL", "user.email"},
+}
+
+// LoadConfig loads the layered configuration, see LoadConfigSources. Values
+// of the wrong type are an error, unknown keys are warned about.
 func LoadConfig() (*Config, error) {
-	home, err := homedir.Dir()
+	srcs, err := LoadConfigSources()
 	if err != nil {
 		return nil, err
 	}
 
-	// first check $HOME/.gxrc
-	cfg, err := loadFile(filepath.Join(home, CfgFileName))
+	var errs []string
+	for _, src := range srcs {
+		for _, ce := range ValidateConfig(src) {
+			if ce.Unknown {
+				// not on stdout, where it would end up in --json output
+				fmt.Fprintf(os.Stderr, "warning: %s\n", ce)
+				continue
+			}
+			errs = append(errs, ce.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config:\n  %s", strings.Join(errs, "\n  "))
+	}
+
+	cfg, err := mapToCfg(MergeConfigSources(srcs))
 	if err != nil {
-		if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	sanityFill(cfg)
+	return cfg, nil
+}
+
+// LoadConfigSources returns the layers of configuration, lowest precedence
+// first: the config files of ConfigPaths, then the GX_* environment
+// variables
+func LoadConfigSources() ([]*ConfigSource, error) {
+	paths, err := ConfigPaths()
+	if err != nil {
+		return nil, err
+	}
+
+	var out []*ConfigSource
+	for _, p := range paths {
+		vals, err := LoadConfigFile(p)
+		if err != nil {
 			return nil, err
 		}
+
+		if len(vals) > 0 {
+			out = append(out, &ConfigSource{Name: p, Values: vals})
+		}
 	}
 
-	cwd, err := os.Getwd()
+	return append(out, envConfigSources()...), nil
+}
+
+// ConfigPaths returns the config files LoadConfig merges, lowest precedence
+// first: the system config, $XDG_CONFIG_HOME/gx/gxrc, ~/.gxrc, and the .gxrc
+// of every directory from the package root down to the current directory
+func ConfigPaths() ([]string, error) {
+	home, err := homedir.Dir()
 	if err != nil {
 		return nil, err
 	}
 
-	local, err := loadFile(filepath.Join(cwd, CfgFileName))
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = filepath.Join(home, ".config")
+	}
+
+	paths := []string{
+		SystemConfigPath,
+		filepath.Join(xdg, "gx", "gxrc"),
+		filepath.Join(home, CfgFileName),
+	}
+
+	dirs, err := projectConfigDirs()
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	for _, p := range paths {
+		seen[p] = true
+	}
+
+	for _, d := range dirs {
+		p := filepath.Join(d, CfgFileName)
+		if !seen[p] {
+			seen[p] = true
+			paths = append(paths, p)
 		}
 	}
+	return paths, nil
+}
 
-	if local == nil {
-		return mapToCfg(cfg)
+// projectConfigDirs returns the directories from the package root down to
+// the current directory, or just the current directory outside of a package
+func projectConfigDirs() ([]string, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
 	}
 
-	if cfg == nil {
-		return mapToCfg(local)
+	root, err := GetPackageRoot()
+	if err != nil {
+		return []string{cwd}, nil
+	}
+
+	rel, err := filepath.Rel(root, cwd)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return []string{cwd}, nil
+	}
+
+	dirs := []string{root}
+	if rel == "." {
+		return dirs, nil
+	}
+
+	cur := root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		cur = filepath.Join(cur, part)
+		dirs = append(dirs, cur)
+	}
+	return dirs, nil
+}
+
+// envConfigSources returns a config source for every GX_* environment
+// variable that is set
+func envConfigSources() []*ConfigSource {
+	var out []*ConfigSource
+	for _, e := range configEnv {
+		v := os.Getenv(e.Var)
+		if v == "" {
+			continue
+		}
+
+		keys := strings.Split(e.Key, ".")
+		vals := make(map[string]interface{})
+		cur := vals
+		for _, k := range keys[:len(keys)-1] {
+			next := make(map[string]interface{})
+			cur[k] = next
+			cur = next
+		}
+		cur[keys[len(keys)-1]] = ParseConfigValue(e.Key, v)
+
+		out = append(out, &ConfigSource{Name: "$" + e.Var, Values: vals})
 	}
+	return out
+}
 
-	merged := mergeMaps(cfg, local)
+// ParseConfigValue converts a value given as a string, on the command line or
+// in an environment variable, to the type of the config key it sets. Values
+// that don't convert are left as strings, for ValidateConfig to report.
+func ParseConfigValue(key, v string) interface{} {
+	t := configKeyType(key)
+	if t == nil {
+		return v
+	}
 
-	return mapToCfg(merged)
+	switch t.Kind() {
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	case reflect.Int, reflect.Int64:
+		if n, err := strconv.Atoi(v); err == nil {
+			return float64(n)
+		}
+	}
+	return v
 }
 
 func mergeMaps(base, extra map[string]interface{}) map[string]interface{} {
@@ -110,25 +382,9 @@ func LoadConfigFrom(paths ...string) (*Config, error) {
 		return nil, fmt.Errorf("no path specified!")
 	}
 
-	base := paths[0]
-	paths = paths[1:]
-
-	cfg, err := loadFile(base)
+	cfg, err := mergeConfigFiles(paths...)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return nil, err
-		}
-	}
-
-	for _, np := range paths {
-		next, err := loadFile(np)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				return nil, err
-			}
-		}
-
-		cfg = mergeMaps(cfg, next)
+		return nil, err
 	}
 
 	rcfg, err := mapToCfg(cfg)
@@ -140,6 +396,31 @@ func LoadConfigFrom(paths ...string) (*Config, error) {
 	return rcfg, nil
 }
 
+// MergeConfigSources merges the values of the given config sources, later
+// ones taking precedence. The values of the sources are modified.
+func MergeConfigSources(srcs []*ConfigSource) map[string]interface{} {
+	var cfg map[string]interface{}
+	for _, src := range srcs {
+		cfg = mergeMaps(cfg, src.Values)
+	}
+	return cfg
+}
+
+// mergeConfigFiles reads the given config files as json objects and merges
+// them, later files taking precedence
+func mergeConfigFiles(paths ...string) (map[string]interface{}, error) {
+	var cfg map[string]interface{}
+	for _, p := range paths {
+		next, err := LoadConfigFile(p)
+		if err != nil {
+			return nil, err
+		}
+
+		cfg = mergeMaps(cfg, next)
+	}
+	return cfg, nil
+}
+
 func sanityFill(c *Config) {
 	if c.ExtraRepos == nil {
 		c.ExtraRepos = make(map[string]string)
@@ -148,6 +429,14 @@ func sanityFill(c *Config) {
 	if c.Repos == nil {
 		c.Repos = make(map[string]string)
 	}
+
+	if c.CommunityRepos == nil {
+		c.CommunityRepos = make(map[string]string)
+	}
+
+	if c.RepoPriorities == nil {
+		c.RepoPriorities = make(map[string]int)
+	}
 }
 
 func loadFile(fname string) (map[string]interface{}, error) {
@@ -193,3 +482,118 @@ func WriteConfig(cfg *Config, file string) error {
 	defer fi.Close()
 	return json.NewEncoder(fi).Encode(cfg)
 }
+
+// repoConfigKeys are the keys of the config written by SaveConfigRepos
+var repoConfigKeys = []string{"repos", "extra_repos", "community_repos", "repo_priorities"}
+
+// SaveConfigRepos writes the repos of cfg, with their priorities, to the
+// config file fname, leaving its other values as they are
+func SaveConfigRepos(cfg *Config, fname string) error {
+	vals, err := LoadConfigFile(fname)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	var all map[string]interface{}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return err
+	}
+
+	for _, k := range repoConfigKeys {
+		if v, ok := all[k]; ok {
+			vals[k] = v
+		} else {
+			delete(vals, k)
+		}
+	}
+
+	return SaveConfigFile(vals, fname)
+}
+
+// LoadConfigFile reads a single config file as a json object, which is empty
+// if the file does not exist
+func LoadConfigFile(fname string) (map[string]interface{}, error) {
+	cfg, err := loadFile(fname)
+	switch {
+	case os.IsNotExist(err):
+		return make(map[string]interface{}), nil
+	case err != nil:
+		return nil, fmt.Errorf("reading %s: %s", fname, err)
+	case cfg == nil:
+		return make(map[string]interface{}), nil
+	}
+	return cfg, nil
+}
+
+// SaveConfigFile writes cfg to fname, after checking that its values have
+// the right types
+func SaveConfigFile(cfg map[string]interface{}, fname string) error {
+	for _, ce := range ValidateConfig(&ConfigSource{Name: fname, Values: cfg}) {
+		if !ce.Unknown {
+			return fmt.Errorf("invalid config: %s", ce)
+		}
+	}
+
+	return writeJson(cfg, fname)
+}
+
+// ConfigValue is a value of the merged config, keyed by its query path, and
+// the file it comes from
+type ConfigValue struct {
+	Key    string      `json:"key"`
+	Value  interface{} `json:"value"`
+	Source string      `json:"source"`
+}
+
+// ConfigValues returns the values of the config merged from the given
+// sources, sorted by key. Like mergeMaps, objects are merged and any other
+// value in a later source replaces the earlier one.
+func ConfigValues(srcs []*ConfigSource) []*ConfigValue {
+	vals := make(map[string]*ConfigValue)
+	for _, src := range srcs {
+		for k, v := range src.Values {
+			setConfigValue(vals, k, v, src.Name)
+		}
+	}
+
+	out := make([]*ConfigValue, 0, len(vals))
+	for _, v := range vals {
+		out = append(out, v)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+func setConfigValue(vals map[string]*ConfigValue, key string, v interface{}, src string) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		// replaces whatever was there, including all values of an object
+		for k := range vals {
+			if strings.HasPrefix(k, key+".") {
+				delete(vals, k)
+			}
+		}
+		vals[key] = &ConfigValue{Key: key, Value: v, Source: src}
+		return
+	}
+
+	delete(vals, key)
+	for k, cv := range obj {
+		setConfigValue(vals, key+"."+k, cv, src)
+	}
+
+	if len(obj) == 0 {
+		for k := range vals {
+			if strings.HasPrefix(k, key+".") {
+				return
+			}
+		}
+		vals[key] = &ConfigValue{Key: key, Value: obj, Source: src}
+	}
+}
@@ -0,0 +1,310 @@
+package gxutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	homedir "github.com/mitchellh/go-homedir"
+	. "github.com/whyrusleeping/stump"
+)
+
+// configDirs points every config location at a fresh temporary directory:
+// the system config, the home directory and a package with a subdirectory,
+// which becomes the current directory. It returns the directory and a
+// function restoring the environment.
+func configDirs(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "gx-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, d := range []string{"etc", "home/.config/gx", "pkg/sub"} {
+		if err := os.MkdirAll(filepath.Join(dir, d), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "pkg", PkgFileName), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(filepath.Join(dir, "pkg", "sub")); err != nil {
+		t.Fatal(err)
+	}
+
+	env := make(map[string]string)
+	for _, e := range append([]string{"HOME", "XDG_CONFIG_HOME"}, envVars()...) {
+		env[e] = os.Getenv(e)
+		os.Unsetenv(e)
+	}
+	os.Setenv("HOME", filepath.Join(dir, "home"))
+
+	syscfg := SystemConfigPath
+	SystemConfigPath = filepath.Join(dir, "etc", "gxrc")
+	homedir.DisableCache = true
+
+	return dir, func() {
+		SystemConfigPath = syscfg
+		homedir.DisableCache = false
+		for k, v := range env {
+			if v == "" {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, v)
+			}
+		}
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	}
+}
+
+func envVars() []string {
+	var out []string
+	for _, e := range configEnv {
+		out = append(out, e.Var)
+	}
+	return out
+}
+
+func writeConfig(t *testing.T, p, data string) {
+	if err := ioutil.WriteFile(p, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigLayers(t *testing.T) {
+	dir, done := configDirs(t)
+	defer done()
+
+	writeConfig(t, filepath.Join(dir, "etc", "gxrc"), `{
+		"repos": {"system": "/ipns/system", "shadowed": "/ipns/system"},
+		"user": {"name": "system", "email": "system@example.com"},
+		"cache": {"ttl": "1m"}
+	}`)
+	writeConfig(t, filepath.Join(dir, "home", ".config", "gx", "gxrc"), `{
+		"user": {"name": "xdg"}
+	}`)
+	writeConfig(t, filepath.Join(dir, "home", CfgFileName), `{
+		"repos": {"shadowed": "/ipns/home"},
+		"store": {"type": "local", "path": "/home/store"}
+	}`)
+	writeConfig(t, filepath.Join(dir, "pkg", CfgFileName), `{
+		"extra_repos": {"pkg": "/ipns/pkg"},
+		"cache": {"ttl": "2m"}
+	}`)
+	writeConfig(t, filepath.Join(dir, "pkg", "sub", CfgFileName), `{
+		"cache": {"ttl": "3m"}
+	}`)
+	os.Setenv("GX_STORE_PATH", "/env/store")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checks := []struct {
+		what     string
+		got, exp string
+	}{
+		// objects are merged key by key
+		{"system repo", cfg.Repos["system"], "/ipns/system"},
+		{"user email", cfg.User.Email, "system@example.com"},
+
+		// later layers win
+		{"home repo", cfg.Repos["shadowed"], "/ipns/home"},
+		{"user name", cfg.User.Name, "xdg"},
+		{"package repo", cfg.ExtraRepos["pkg"], "/ipns/pkg"},
+		{"subdirectory ttl", cfg.Cache.TTL, "3m"},
+
+		// the environment wins over every file
+		{"store type", cfg.Store.Type, "local"},
+		{"store path", cfg.Store.Path, "/env/store"},
+	}
+	for _, c := range checks {
+		if c.got != c.exp {
+			t.Errorf("%s: expected %q, got %q", c.what, c.exp, c.got)
+		}
+	}
+
+	srcs, err := LoadConfigSources()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sources := make(map[string]string)
+	for _, v := range ConfigValues(srcs) {
+		sources[v.Key] = v.Source
+	}
+
+	expected := map[string]string{
+		"repos.system":   SystemConfigPath,
+		"repos.shadowed": filepath.Join(dir, "home", CfgFileName),
+		"cache.ttl":      filepath.Join(dir, "pkg", "sub", CfgFileName),
+		"store.path":     "$GX_STORE_PATH",
+	}
+	for k, src := range expected {
+		if sources[k] != src {
+			t.Errorf("%s: expected source %s, got %s", k, src, sources[k])
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	dir, done := configDirs(t)
+	defer done()
+
+	// unknown keys only warn, and not on stdout
+	writeConfig(t, filepath.Join(dir, "home", CfgFileName), `{"bogus": true}`)
+
+	var logged bytes.Buffer
+	LogOut = &logged
+	defer func() { LogOut = os.Stdout }()
+
+	errf, err := ioutil.TempFile(dir, "stderr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = errf
+	_, err = LoadConfig()
+	os.Stderr = stderr
+	errf.Close()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged.Len() > 0 {
+		t.Fatalf("unexpected output on stdout: %q", logged.String())
+	}
+
+	warnings, err := ioutil.ReadFile(errf.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(warnings), "bogus") {
+		t.Fatalf("expected a warning about the unknown key, got %q", warnings)
+	}
+
+	// values of the wrong type are an error, wherever they come from
+	writeConfig(t, filepath.Join(dir, "pkg", CfgFileName), `{"cache": {"ttl": 5}}`)
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "cache.ttl") {
+		t.Fatalf("expected an error about cache.ttl, got %v", err)
+	}
+
+	os.Remove(filepath.Join(dir, "pkg", CfgFileName))
+	os.Setenv("GX_CHECKOUTS", "maybe")
+	if _, err := LoadConfig(); err == nil || !strings.Contains(err.Error(), "GX_CHECKOUTS") {
+		t.Fatalf("expected an error about GX_CHECKOUTS, got %v", err)
+	}
+}
+
+func TestParseConfigValue(t *testing.T) {
+	cases := []struct {
+		key, value string
+		expected   interface{}
+	}{
+		{"no_verify", "true", true},
+		{"checkouts.enabled", "0", false},
+		{"repo_priorities.myrepo", "500", float64(500)},
+		{"cache.ttl", "3600", "3600"},
+		{"user.name", "true", "true"},
+		{"no_verify", "maybe", "maybe"},
+		{"bogus", "5", "5"},
+	}
+
+	for _, c := range cases {
+		if v := ParseConfigValue(c.key, c.value); v != c.expected {
+			t.Errorf("%s %q: expected %#v, got %#v", c.key, c.value, c.expected, v)
+		}
+	}
+}
+
+func TestEnvReadThroughConfig(t *testing.T) {
+	dir, done := configDirs(t)
+	defer done()
+
+	os.Setenv("GX_STORE", "bogus")
+	os.Setenv("GX_CACHE_TTL", "5m")
+	os.Setenv("GX_CHECKOUTS", "true")
+
+	// the environment only counts through LoadConfig
+	pm := &PM{cfg: &Config{Store: StoreConfig{Type: StoreLocal, Path: filepath.Join(dir, "store")}}}
+	if _, err := NewContentStore(pm.cfg); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := pm.CacheTTL(); ttl != DefaultCacheTTL {
+		t.Errorf("expected the default cache ttl, got %s", ttl)
+	}
+	if pm.checkoutsEnabled() {
+		t.Error("expected checkouts to be disabled")
+	}
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm = &PM{cfg: cfg}
+	if _, err := NewContentStore(cfg); err == nil || !strings.Contains(err.Error(), "bogus") {
+		t.Errorf("expected an error about the bogus store, got %v", err)
+	}
+	if ttl := pm.CacheTTL(); ttl != 5*time.Minute {
+		t.Errorf("expected a cache ttl of 5m, got %s", ttl)
+	}
+	if !pm.checkoutsEnabled() {
+		t.Error("expected checkouts to be enabled")
+	}
+}
+
+func TestSaveConfigRepos(t *testing.T) {
+	dir, done := configDirs(t)
+	defer done()
+
+	p := filepath.Join(dir, "pkg", CfgFileName)
+	writeConfig(t, p, `{
+		"user": {"name": "pkg"},
+		"bogus": 1,
+		"repos": {"a": "/ipns/a"},
+		"repo_priorities": {"a": 5}
+	}`)
+
+	cfg, err := LoadConfigFrom(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	delete(cfg.Repos, "a")
+	delete(cfg.RepoPriorities, "a")
+	cfg.ExtraRepos["b"] = "/ipns/b"
+	cfg.NoVerify = true
+
+	if err := SaveConfigRepos(cfg, p); err != nil {
+		t.Fatal(err)
+	}
+
+	// only the repos change, nothing else of cfg is written
+	out, err := ioutil.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	exp := map[string]interface{}{
+		"user":        map[string]interface{}{"name": "pkg"},
+		"bogus":       float64(1),
+		"extra_repos": map[string]interface{}{"b": "/ipns/b"},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected %v, got %v", exp, got)
+	}
+}
@@ -0,0 +1,210 @@
+package gxutil
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ConfigError is a problem with a value of a config source
+type ConfigError struct {
+	Source string
+	Key    string
+	Msg    string
+
+	// Unknown is set if the key is not a config option, which older
+	// versions of gx may see in configs written for newer ones
+	Unknown bool
+}
+
+func (ce *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ce.Source, ce.Key, ce.Msg)
+}
+
+var configType = reflect.TypeOf(Config{})
+
+// ValidateConfig checks the values of a config source against the options of
+// Config, reporting unknown keys and values of the wrong type
+func ValidateConfig(src *ConfigSource) []*ConfigError {
+	var out []*ConfigError
+	report := func(key, msg string, unknown bool) {
+		out = append(out, &ConfigError{
+			Source:  src.Name,
+			Key:     key,
+			Msg:     msg,
+			Unknown: unknown,
+		})
+	}
+
+	checkConfigValue(configType, "", src.Values, report)
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Key < out[j].Key
+	})
+	return out
+}
+
+// configKeyType returns the type of the config option at the dotted key, or
+// nil if there is no such option
+func configKeyType(key string) reflect.Type {
+	t := configType
+	for _, k := range strings.Split(key, ".") {
+		switch t.Kind() {
+		case reflect.Struct:
+			f, ok := configField(t, k)
+			if !ok {
+				return nil
+			}
+			t = f.Type
+		case reflect.Map:
+			t = t.Elem()
+		default:
+			return nil
+		}
+
+		if t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+	}
+	return t
+}
+
+// configField finds the field of t that encoding/json decodes key into
+func configField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var fold *reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = f.Name
+		}
+
+		if name == key {
+			return f, true
+		}
+		if fold == nil && strings.EqualFold(name, key) {
+			fold = &f
+		}
+	}
+
+	if fold != nil {
+		return *fold, true
+	}
+	return reflect.StructField{}, false
+}
+
+func checkConfigValue(t reflect.Type, key string, v interface{}, report func(key, msg string, unknown bool)) {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if v == nil {
+		// null decodes to the zero value of anything
+		return
+	}
+
+	mismatch := func() {
+		report(key, fmt.Sprintf("expected %s, got %s", describeConfigType(t), describeJsonValue(v)), false)
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+
+		for k, cv := range obj {
+			f, ok := configField(t, k)
+			if !ok {
+				report(joinConfigKey(key, k), "unknown config option", true)
+				continue
+			}
+			checkConfigValue(f.Type, joinConfigKey(key, k), cv, report)
+		}
+	case reflect.Map:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+
+		for k, cv := range obj {
+			checkConfigValue(t.Elem(), joinConfigKey(key, k), cv, report)
+		}
+	case reflect.Slice:
+		arr, ok := v.([]interface{})
+		if !ok {
+			mismatch()
+			return
+		}
+
+		for i, cv := range arr {
+			checkConfigValue(t.Elem(), fmt.Sprintf("%s[%d]", key, i), cv, report)
+		}
+	case reflect.String:
+		if _, ok := v.(string); !ok {
+			mismatch()
+		}
+	case reflect.Bool:
+		if _, ok := v.(bool); !ok {
+			mismatch()
+		}
+	case reflect.Int, reflect.Int64:
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			mismatch()
+		}
+	}
+}
+
+func joinConfigKey(key, k string) string {
+	if key == "" {
+		return k
+	}
+	return key + "." + k
+}
+
+func describeConfigType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Struct, reflect.Map:
+		return "an object"
+	case reflect.Slice:
+		return "an array"
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int64:
+		return "an integer"
+	default:
+		return t.String()
+	}
+}
+
+func describeJsonValue(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return "an object"
+	case []interface{}:
+		return "an array"
+	case string:
+		return fmt.Sprintf("string %q", v)
+	case bool:
+		return fmt.Sprintf("boolean %t", v)
+	case float64:
+		return fmt.Sprintf("number %v", v)
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
@@ -0,0 +1,432 @@
+package gxutil
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/blang/semver"
+	. "github.com/whyrusleeping/stump"
+)
+
+// ForgeConfig describes how to read files from the repositories of a code
+// forge, configured per host pattern in the 'resolvers' section of .gxrc
+type ForgeConfig struct {
+	// Raw is the url template of a raw file in a repository. "{repo}" is
+	// replaced by the path of the repository below the host, "{ref}" by the
+	// branch or tag and "{path}" by the path of the file.
+	Raw string `json:"raw"`
+
+	// Branch is used when a reference names no branch or tag
+	Branch string `json:"branch,omitempty"`
+
+	// Auth is an http header, "Name: value", sent with every request.
+	// Environment variables in it are expanded.
+	Auth string `json:"auth,omitempty"`
+
+	// Tags is the url template of the api listing the tags of a repository,
+	// as a json array of objects with a "name" field. "{repo_escaped}" is
+	// replaced by the path escaped repository path. It is optional and only
+	// used to list available versions in errors.
+	Tags string `json:"tags,omitempty"`
+}
+
+var defaultForges = map[string]*ForgeConfig{
+	"github.com": {
+		Raw:    "https://raw.githubusercontent.com/{repo}/{ref}/{path}",
+		Branch: "master",
+		Tags:   "https://api.github.com/repos/{repo}/tags",
+	},
+	"gitlab.com": {
+		Raw:    "https://gitlab.com/{repo}/-/raw/{ref}/{path}",
+		Branch: "master",
+		Tags:   "https://gitlab.com/api/v4/projects/{repo_escaped}/repository/tags",
+	},
+}
+
+// ForgeRef is a reference to a repository on a code forge, written as
+// "host/path/to/repo" or "host/path/to/repo@ref"
+type ForgeRef struct {
+	Host string
+	Repo string
+	Ref  string
+
+	forge *ForgeConfig
+
+	// local git checkout of the repository, and the git ref to read in it
+	// if Ref is empty
+	checkout    string
+	checkoutRef string
+}
+
+func (fr *ForgeRef) String() string {
+	s := fr.Host + "/" + fr.Repo
+	if fr.Ref != "" {
+		s += "@" + fr.Ref
+	}
+	return s
+}
+
+// RawURL returns the url of the raw file at p in the repository
+func (fr *ForgeRef) RawURL(p string) string {
+	return fr.rawURL(fr.Ref, p)
+}
+
+func (fr *ForgeRef) rawURL(ref, p string) string {
+	if ref == "" {
+		ref = fr.forge.Branch
+	}
+	if ref == "" {
+		ref = "master"
+	}
+
+	return strings.NewReplacer(
+		"{repo}", fr.Repo,
+		"{ref}", ref,
+		"{path}", p,
+	).Replace(fr.forge.Raw)
+}
+
+// versionTags returns the tags a version ref may be released under, or nil
+// if the ref is not a version. Only refs with at least a major and a minor
+// version, like "1.2" or "v1.2.3", count as versions. Shorter ones like "v2"
+// are just as likely to be branches, see shortVersionTags.
+func (fr *ForgeRef) versionTags() []string {
+	if strings.Count(fr.Ref, ".") == 0 {
+		return nil
+	}
+	return tagsOfVersion(fr.Ref)
+}
+
+// shortVersionTags returns the tags a ref with only a major version, like
+// "v2", may be released under, other than the ref itself
+func (fr *ForgeRef) shortVersionTags() []string {
+	var out []string
+	for _, t := range tagsOfVersion(fr.Ref) {
+		if t != fr.Ref {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+func tagsOfVersion(ref string) []string {
+	if _, err := semver.ParseTolerant(ref); err != nil {
+		return nil
+	}
+
+	if strings.HasPrefix(ref, "v") {
+		return []string{ref, ref[1:]}
+	}
+	return []string{"v" + ref, ref}
+}
+
+// isRangeRef reports whether ref starts with a range operator, as in
+// "^1.2.0" or ">=1.0"
+func isRangeRef(ref string) bool {
+	return ref != "" && strings.ContainsAny(ref[:1], "^~<>=")
+}
+
+// refMatches reports whether vers is the version named by ref. A partial
+// ref like "v1.2" matches any version it is a prefix of.
+func refMatches(vers, ref string) bool {
+	v, err := semver.Parse(strings.TrimPrefix(vers, "v"))
+	if err != nil {
+		return false
+	}
+
+	r, err := ParseVersionRange(strings.TrimPrefix(ref, "v"))
+	return err == nil && r(v)
+}
+
+// forges returns the configured forges on top of the built in ones
+func (pm *PM) forges() map[string]*ForgeConfig {
+	out := make(map[string]*ForgeConfig)
+	for k, v := range defaultForges {
+		out[k] = v
+	}
+
+	if pm.cfg != nil {
+		for k, v := range pm.cfg.Resolvers {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// ParseForgeRef parses name as a forge reference, reporting false if its
+// host matches none of the configured forges. Host patterns are matched with
+// path.Match, an exact host wins over patterns and longer patterns win over
+// shorter ones.
+func (pm *PM) ParseForgeRef(name string) (*ForgeRef, bool) {
+	parts := strings.SplitN(name, "/", 2)
+	if len(parts) < 2 || parts[1] == "" {
+		return nil, false
+	}
+	host, repo := parts[0], parts[1]
+
+	var best string
+	var forge *ForgeConfig
+	for pat, f := range pm.forges() {
+		if ok, _ := path.Match(pat, host); !ok {
+			continue
+		}
+
+		switch {
+		case forge == nil, pat == host:
+			best, forge = pat, f
+		case best != host && len(pat) > len(best):
+			best, forge = pat, f
+		case best != host && len(pat) == len(best) && pat < best:
+			best, forge = pat, f
+		}
+	}
+
+	if forge == nil {
+		return nil, false
+	}
+
+	var ref string
+	if i := strings.LastIndex(repo, "@"); i >= 0 {
+		repo, ref = repo[:i], repo[i+1:]
+	}
+
+	repo = strings.Trim(repo, "/")
+	return &ForgeRef{
+		Host:        host,
+		Repo:        repo,
+		Ref:         ref,
+		forge:       forge,
+		checkout:    pm.findCheckout(host, repo),
+		checkoutRef: pm.checkoutDefaultRef(),
+	}, true
+}
+
+// resolveForgeDep resolves a forge reference to the hash in the
+// .gx/lastpubver file of the repository. If the reference names a version,
+// the file is read at the tag of that version.
+func (pm *PM) resolveForgeDep(fr *ForgeRef) (string, error) {
+	if isRangeRef(fr.Ref) {
+		return fr.resolveRange()
+	}
+
+	tags := fr.versionTags()
+	if tags == nil {
+		vers, hash, err := fr.lastPubVer(fr.Ref)
+		if err != nil {
+			return "", err
+		}
+		if hash != "" {
+			VLog("  - resolved %q to %s, version %s", fr.String(), hash, vers)
+			return hash, nil
+		}
+
+		// not a branch or tag, but it may still be a short version
+		tags = fr.shortVersionTags()
+		if len(tags) == 0 {
+			return "", fmt.Errorf("no gx package found at %s", fr)
+		}
+	}
+
+	for _, tag := range tags {
+		vers, hash, err := fr.lastPubVer(tag)
+		if err != nil {
+			return "", err
+		}
+		if hash == "" {
+			continue
+		}
+
+		if !refMatches(vers, fr.Ref) {
+			return "", fmt.Errorf("tag %s of %s/%s has version %s in .gx/lastpubver, not %s", tag, fr.Host, fr.Repo, vers, fr.Ref)
+		}
+
+		VLog("  - resolved %q to %s at tag %s", fr.String(), hash, tag)
+		return hash, nil
+	}
+
+	msg := fmt.Sprintf("version %s of %s/%s not found, no gx package at tag %s", fr.Ref, fr.Host, fr.Repo, strings.Join(tagsOfVersion(fr.Ref), " or "))
+	avail, err := fr.listVersionTags()
+	if err != nil {
+		VLog("  - listing tags of %s/%s: %s", fr.Host, fr.Repo, err)
+	} else if len(avail) > 0 {
+		msg += ", available versions: " + strings.Join(avail, ", ")
+	}
+	return "", errors.New(msg)
+}
+
+// resolveRange resolves a ref with a range operator to the highest tagged
+// version in range that has a gx package
+func (fr *ForgeRef) resolveRange() (string, error) {
+	r, err := ParseVersionRange(fr.Ref)
+	if err != nil {
+		return "", err
+	}
+
+	names, err := fr.listTags()
+	if err != nil {
+		return "", fmt.Errorf("listing tags of %s/%s: %s", fr.Host, fr.Repo, err)
+	}
+
+	type tagVersion struct {
+		tag     string
+		version semver.Version
+	}
+
+	var tags []tagVersion
+	for _, n := range names {
+		v, err := semver.ParseTolerant(n)
+		if err == nil && r(v) {
+			tags = append(tags, tagVersion{tag: n, version: v})
+		}
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		return tags[i].version.GT(tags[j].version)
+	})
+
+	for _, t := range tags {
+		vers, hash, err := fr.lastPubVer(t.tag)
+		if err != nil {
+			return "", err
+		}
+		if hash == "" {
+			continue
+		}
+
+		VLog("  - resolved %q to %s at tag %s, version %s", fr.String(), hash, t.tag, vers)
+		return hash, nil
+	}
+
+	return "", fmt.Errorf("no gx package at a tag of %s/%s in range %s", fr.Host, fr.Repo, fr.Ref)
+}
+
+func (fr *ForgeRef) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if fr.forge.Auth != "" {
+		hdr := strings.SplitN(os.ExpandEnv(fr.forge.Auth), ":", 2)
+		if len(hdr) != 2 {
+			return nil, fmt.Errorf("invalid auth header for %s, expected 'Name: value'", fr.Host)
+		}
+		req.Header.Set(strings.TrimSpace(hdr[0]), strings.TrimSpace(hdr[1]))
+	}
+
+	return http.DefaultClient.Do(req)
+}
+
+// lastPubVer reads .gx/lastpubver at ref, returning an empty hash if the
+// file does not exist there. A local checkout of the repository is read
+// first, if there is one.
+func (fr *ForgeRef) lastPubVer(ref string) (string, string, error) {
+	if fr.checkout != "" {
+		gref := ref
+		if gref == "" {
+			gref = fr.checkoutRef
+		}
+
+		if vers, hash := checkoutLastPubVer(fr.checkout, gref); hash != "" {
+			return vers, hash, nil
+		}
+		VLog("  - %s not found in %s, trying %s", gref, fr.checkout, fr.Host)
+	}
+
+	resp, err := fr.get(fr.rawURL(ref, ".gx/lastpubver"))
+	if err != nil {
+		if fr.checkout != "" {
+			// likely offline, go with what the checkout has
+			VLog("  - fetching .gx/lastpubver from %s: %s", fr.Host, err)
+			return "", "", nil
+		}
+		return "", "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		out, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return "", "", err
+		}
+
+		parts := strings.Split(string(out), ": ")
+		if len(parts) < 2 {
+			return "", "", fmt.Errorf("unrecognized format on .gx/lastpubver")
+		}
+		return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+	case 404:
+		return "", "", nil
+	default:
+		return "", "", fmt.Errorf("unrecognized http response from %s: %d: %s", fr.Host, resp.StatusCode, resp.Status)
+	}
+}
+
+// listVersionTags returns the tags of the repository that look like
+// versions, highest first
+func (fr *ForgeRef) listVersionTags() ([]string, error) {
+	names, err := fr.listTags()
+	if err != nil {
+		return nil, err
+	}
+
+	var vers []*PkgVersion
+	for _, n := range names {
+		if _, err := semver.ParseTolerant(n); err == nil {
+			vers = append(vers, &PkgVersion{Version: strings.TrimPrefix(n, "v")})
+		}
+	}
+	sortVersions(vers)
+
+	out := make([]string, 0, len(vers))
+	for _, v := range vers {
+		out = append(out, v.Version)
+	}
+	return out, nil
+}
+
+func (fr *ForgeRef) listTags() ([]string, error) {
+	if fr.checkout != "" {
+		return checkoutTags(fr.checkout)
+	}
+
+	if fr.forge.Tags == "" {
+		return nil, nil
+	}
+
+	url := strings.NewReplacer(
+		"{repo_escaped}", neturl.PathEscape(fr.Repo),
+		"{repo}", fr.Repo,
+	).Replace(fr.forge.Tags)
+
+	resp, err := fr.get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("unrecognized http response: %d: %s", resp.StatusCode, resp.Status)
+	}
+
+	var tags []struct {
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, err
+	}
+
+	out := make([]string, 0, len(tags))
+	for _, t := range tags {
+		out = append(out, t.Name)
+	}
+	return out, nil
+}
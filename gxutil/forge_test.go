@@ -0,0 +1,129 @@
+package gxutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestVersionTags(t *testing.T) {
+	cases := []struct {
+		ref   string
+		tags  []string
+		short []string
+	}{
+		{ref: "1.2.0", tags: []string{"v1.2.0", "1.2.0"}, short: []string{"v1.2.0"}},
+		{ref: "v1.2.0", tags: []string{"v1.2.0", "1.2.0"}, short: []string{"1.2.0"}},
+		{ref: "v1.2", tags: []string{"v1.2", "1.2"}, short: []string{"1.2"}},
+		{ref: "v2", short: []string{"2"}},
+		{ref: "2", short: []string{"v2"}},
+		{ref: "master"},
+		{ref: "release-1.2"},
+		{ref: ""},
+	}
+
+	for _, c := range cases {
+		fr := &ForgeRef{Ref: c.ref}
+		if tags := fr.versionTags(); !reflect.DeepEqual(tags, c.tags) {
+			t.Errorf("%q: expected version tags %v, got %v", c.ref, c.tags, tags)
+		}
+		if short := fr.shortVersionTags(); !reflect.DeepEqual(short, c.short) {
+			t.Errorf("%q: expected short version tags %v, got %v", c.ref, c.short, short)
+		}
+	}
+}
+
+// fakeForge serves .gx/lastpubver files and the tags api for a single
+// repository, "org/pkg". files maps refs to the contents of lastpubver.
+func fakeForge(files map[string]string) (*PM, func()) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/tags/org/pkg" {
+			var tags []map[string]string
+			for ref := range files {
+				tags = append(tags, map[string]string{"name": ref})
+			}
+			json.NewEncoder(w).Encode(tags)
+			return
+		}
+
+		ref := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/raw/org/pkg/"), "/.gx/lastpubver")
+		data, ok := files[ref]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, data)
+	}))
+
+	cfg := &Config{
+		Resolvers: map[string]*ForgeConfig{
+			"forge.test": {
+				Raw:    srv.URL + "/raw/{repo}/{ref}/{path}",
+				Branch: "master",
+				Tags:   srv.URL + "/tags/{repo}",
+			},
+		},
+	}
+	return &PM{cfg: cfg}, srv.Close
+}
+
+func TestResolveForgeDep(t *testing.T) {
+	pm, done := fakeForge(map[string]string{
+		"master": "3.0.0: QmMaster",
+		"v2":     "2.5.0: QmBranch",
+		"v1.2.0": "1.2.0: QmV120",
+		"v1.3.1": "1.3.1: QmV131",
+		"4":      "4.0.0: QmV4",
+	})
+	defer done()
+
+	cases := []struct {
+		ref  string
+		hash string
+		fail bool
+	}{
+		{ref: "", hash: "QmMaster"},
+		{ref: "master", hash: "QmMaster"},
+		{ref: "1.2.0", hash: "QmV120"},
+		{ref: "v1.3.1", hash: "QmV131"},
+
+		// a branch that looks like a major version
+		{ref: "v2", hash: "QmBranch"},
+
+		// a short version that is not a branch or tag by itself
+		{ref: "v4", hash: "QmV4"},
+
+		{ref: "^1.2.0", hash: "QmV131"},
+		{ref: "~1.2.0", hash: "QmV120"},
+		{ref: ">=1.0 <1.3", hash: "QmV120"},
+		{ref: "^5.0.0", fail: true},
+		{ref: "1.4.0", fail: true},
+		{ref: "nope", fail: true},
+	}
+
+	for _, c := range cases {
+		name := "forge.test/org/pkg"
+		if c.ref != "" {
+			name += "@" + c.ref
+		}
+
+		fr, ok := pm.ParseForgeRef(name)
+		if !ok {
+			t.Fatalf("%s is not a forge ref", name)
+		}
+
+		hash, err := pm.resolveForgeDep(fr)
+		switch {
+		case c.fail && err == nil:
+			t.Errorf("%q: expected an error, got %s", c.ref, hash)
+		case !c.fail && err != nil:
+			t.Errorf("%q: %s", c.ref, err)
+		case hash != c.hash:
+			t.Errorf("%q: expected %s, got %s", c.ref, c.hash, hash)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"path/filepath"
 	"time"
 
+	cid "github.com/ipfs/go-cid"
 	stump "github.com/whyrusleeping/stump"
 )
 
@@ -121,14 +122,14 @@ func (pm *PM) tryFetch(hash, target string) error {
 	}
 
 	begin := time.Now()
-	stump.VLog("  - fetching %s via ipfs api", hash)
+	stump.VLog("  - fetching %s", hash)
 	defer func() {
 		stump.VLog("  - fetch finished in %s", time.Since(begin))
 	}()
 	tries := 3
 	for i := 0; i < tries; i++ {
-		if err := pm.Shell().Get(hash, temp); err != nil {
-			stump.Error("from shell.Get(): %v", err)
+		if err := pm.Store().Get(hash, temp); err != nil {
+			stump.Error("from store.Get(): %v", err)
 
 			rmerr := os.RemoveAll(temp)
 			if rmerr != nil {
@@ -141,6 +142,13 @@ func (pm *PM) tryFetch(hash, target string) error {
 			stump.Log("retrying fetch %s after a second...", hash)
 			time.Sleep(time.Second)
 		} else {
+			if err := pm.verifyFetched(temp, hash); err != nil {
+				if rmerr := os.RemoveAll(temp); rmerr != nil {
+					stump.Error("cleaning up temp download directory: %s", rmerr)
+				}
+				return fmt.Errorf("refusing to install %s: %s", hash, err)
+			}
+
 			/*
 				if err := chmodR(temp, 0444); err != nil {
 					return err
@@ -152,6 +160,73 @@ func (pm *PM) tryFetch(hash, target string) error {
 	panic("unreachable")
 }
 
+// verifyEnabled reports whether fetched content is checked against its
+// hash, which the no_verify config option turns off
+func (pm *PM) verifyEnabled() bool {
+	return pm.cfg == nil || !pm.cfg.NoVerify
+}
+
+// verifyFetched checks the content fetched from ref into dir. Path refs are
+// checked against the root hash they start from, or failing that, against
+// the hash they resolve to.
+func (pm *PM) verifyFetched(dir, ref string) error {
+	if !pm.verifyEnabled() {
+		return nil
+	}
+
+	if IsHash(ref) {
+		return verifyHash(dir, ref)
+	}
+
+	if _, err := localDigest(ref, dir); err == nil {
+		return nil
+	}
+
+	hash, err := pm.Store().Resolve(ref)
+	if err != nil {
+		return fmt.Errorf("resolving %s: %s", ref, err)
+	}
+	return verifyHash(dir, hash)
+}
+
+// verifyHash checks that the file tree at dir hashes to the expected hash
+func verifyHash(dir, expected string) error {
+	exp, err := cid.Decode(expected)
+	if err != nil {
+		return err
+	}
+
+	formats, err := formatsFor(expected)
+	if err != nil {
+		return err
+	}
+	if len(formats) == 0 {
+		return fmt.Errorf("cannot verify content of %s, gx can't compute hashes of its format: use --no-verify to skip the check", expected)
+	}
+
+	var first string
+	for _, f := range formats {
+		actual, err := hashPath(dir, f)
+		if err != nil {
+			return err
+		}
+
+		c, err := cid.Decode(actual)
+		if err != nil {
+			return err
+		}
+		if c.Equals(exp) {
+			return nil
+		}
+
+		if first == "" {
+			first = actual
+		}
+	}
+
+	return fmt.Errorf("content hash mismatch: expected %s, got %s", expected, first)
+}
+
 func chmodR(dir string, perm os.FileMode) error {
 	return filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
 		if p == dir {
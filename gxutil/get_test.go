@@ -0,0 +1,90 @@
+package gxutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func TestVerifyFetched(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := &PM{store: s, cfg: new(Config)}
+
+	pkgdir := filepath.Join(dir, "root", "dep")
+	if err := os.MkdirAll(pkgdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkgdir, "file"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root, err := hashPath(filepath.Join(dir, "root"), defaultFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dep, err := hashPath(pkgdir, defaultFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// checked against the root, without asking the (empty) store
+	for _, ref := range []string{dep, "/ipfs/" + root + "/dep"} {
+		if err := pm.verifyFetched(pkgdir, ref); err != nil {
+			t.Fatalf("%s: %s", ref, err)
+		}
+	}
+
+	// a root with more than the package in it needs the store to resolve
+	// the path
+	if err := ioutil.WriteFile(filepath.Join(dir, "root", "other"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	root2, _, err := buildPath(filepath.Join(dir, "root"), defaultFormat, s.put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pm.verifyFetched(pkgdir, "/ipfs/"+root2+"/dep"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(pkgdir, "file"), []byte("tampered"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{dep, "/ipfs/" + root + "/dep", "/ipfs/" + root2 + "/dep"} {
+		if err := pm.verifyFetched(pkgdir, ref); err == nil {
+			t.Fatalf("%s: tampered content was accepted", ref)
+		}
+	}
+
+	// formats gx can't reproduce are refused rather than trusted
+	sum, err := mh.Sum([]byte("content"), mh.SHA2_512, -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := cid.NewCidV1(cid.DagCBOR, sum).String()
+	if err := pm.verifyFetched(pkgdir, other); err == nil || !strings.Contains(err.Error(), "--no-verify") {
+		t.Fatalf("expected an error pointing at --no-verify, got %v", err)
+	}
+
+	pm.cfg.NoVerify = true
+	for _, ref := range []string{dep, other} {
+		if err := pm.verifyFetched(pkgdir, ref); err != nil {
+			t.Fatalf("no_verify still verified %s: %s", ref, err)
+		}
+	}
+}
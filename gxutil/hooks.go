@@ -0,0 +1,236 @@
+package gxutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	. "github.com/whyrusleeping/stump"
+)
+
+// HookProtocol is the newest hook protocol gx speaks.
+//
+// In protocol 1, gx runs `gx-<lang> hook <name> <args...>` and only looks at
+// its exit code, except for the output of the install-path hook. In protocol
+// 2, gx also writes a HookRequest as json to the stdin of the hook, and reads
+// a HookResponse as json from its stdout. Subtools announce protocol 2 by
+// answering `gx-<lang> hook capabilities` with HookCapabilities; anything
+// else is taken as protocol 1.
+const HookProtocol = 2
+
+// HookCapabilities is the answer of a subtool to the capabilities hook
+type HookCapabilities struct {
+	Protocol int `json:"protocol"`
+
+	// Hooks lists the hooks the subtool implements. If set, no other hooks
+	// are run.
+	Hooks []string `json:"hooks,omitempty"`
+}
+
+func (hc *HookCapabilities) handles(hook string) bool {
+	if len(hc.Hooks) == 0 {
+		return true
+	}
+
+	for _, h := range hc.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+// HookOptions is the context a hook is run in
+type HookOptions struct {
+	// Pkg is the package the hook runs for
+	Pkg *Package
+
+	// Dir is the directory gxHooks scripts of Pkg run in, the current
+	// directory by default
+	Dir string
+
+	// SubtoolDir is the directory the hook of the language subtool runs
+	// in, the current directory by default
+	SubtoolDir string
+
+	// InstallPath is the directory packages are installed into
+	InstallPath string
+
+	Global bool
+
+	// Dependency marks Pkg as a dependency being installed or imported,
+	// rather than the package gx is run in. The gxHooks scripts of
+	// dependencies only run if AllowDepHooks is set.
+	Dependency    bool
+	AllowDepHooks bool
+
+	// Env holds extra environment variables, as "KEY=value"
+	Env []string
+}
+
+// HookRequest is written to the stdin of hooks speaking protocol 2
+type HookRequest struct {
+	Protocol    int           `json:"protocol"`
+	Hook        string        `json:"hook"`
+	Args        []string      `json:"args"`
+	Package     *Package      `json:"package,omitempty"`
+	Deps        []*Dependency `json:"deps,omitempty"`
+	InstallPath string        `json:"installPath,omitempty"`
+	Global      bool          `json:"global"`
+	GxVersion   string        `json:"gxVersion"`
+}
+
+// HookResponse is read from the stdout of hooks speaking protocol 2. All of
+// its fields are optional, and an empty output is an empty response.
+type HookResponse struct {
+	// Warnings are printed to the user
+	Warnings []string `json:"warnings,omitempty"`
+
+	// ModifiedFiles lists the files the hook changed
+	ModifiedFiles []string `json:"modifiedFiles,omitempty"`
+
+	// Actions are run by gx after the hook
+	Actions []*HookAction `json:"actions,omitempty"`
+
+	// InstallPath is the answer to the install-path hook
+	InstallPath string `json:"installPath,omitempty"`
+}
+
+// HookActionRunHook asks gx to run another hook, with the same context
+const HookActionRunHook = "run-hook"
+
+// HookAction is a follow up action requested by a hook
+type HookAction struct {
+	Type string   `json:"type"`
+	Hook string   `json:"hook,omitempty"`
+	Args []string `json:"args,omitempty"`
+}
+
+var (
+	subtoolCaps     = make(map[string]*HookCapabilities)
+	subtoolCapsLock sync.Mutex
+)
+
+// subtoolCapabilities asks the subtool at binname for its capabilities,
+// once per run of gx
+func subtoolCapabilities(binname string) *HookCapabilities {
+	subtoolCapsLock.Lock()
+	defer subtoolCapsLock.Unlock()
+
+	if hc, ok := subtoolCaps[binname]; ok {
+		return hc
+	}
+
+	hc := &HookCapabilities{Protocol: 1}
+	cmd := exec.Command(binname, "hook", "capabilities")
+	cmd.Env = append(os.Environ(), "GX_HOOK_PROTOCOL="+strconv.Itoa(HookProtocol))
+	out, err := cmd.Output()
+	switch {
+	case err != nil:
+		VLog("  - %s does not report capabilities, using hook protocol 1: %s", binname, err)
+	default:
+		var answer HookCapabilities
+		if err := json.Unmarshal(bytes.TrimSpace(out), &answer); err != nil || answer.Protocol < 2 {
+			VLog("  - %s does not report capabilities, using hook protocol 1", binname)
+			break
+		}
+
+		if answer.Protocol > HookProtocol {
+			answer.Protocol = HookProtocol
+		}
+		hc = &answer
+		VLog("  - %s speaks hook protocol %d", binname, hc.Protocol)
+	}
+
+	subtoolCaps[binname] = hc
+	return hc
+}
+
+// runHookProtocol runs a hook of a subtool speaking protocol 2 or later
+func runHookProtocol(binname string, hc *HookCapabilities, hook string, opts *HookOptions, dir string, args []string) (*HookResponse, error) {
+	req := &HookRequest{
+		Protocol:    hc.Protocol,
+		Hook:        hook,
+		Args:        args,
+		Package:     opts.Pkg,
+		InstallPath: opts.InstallPath,
+		Global:      opts.Global,
+		GxVersion:   GxVersion,
+	}
+	if req.Args == nil {
+		req.Args = []string{}
+	}
+	if opts.Pkg != nil {
+		req.Deps = opts.Pkg.Dependencies
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(binname, append([]string{"hook", hook}, args...)...)
+	cmd.Env = append(os.Environ(), opts.Env...)
+	cmd.Env = append(cmd.Env, "GX_HOOK_PROTOCOL="+strconv.Itoa(hc.Protocol))
+	cmd.Dir = dir
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = os.Stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s hook failed: %s", hook, err)
+	}
+
+	resp := new(HookResponse)
+	if len(bytes.TrimSpace(out)) == 0 {
+		return resp, nil
+	}
+
+	if err := json.Unmarshal(out, resp); err != nil {
+		return nil, fmt.Errorf("invalid response from %s hook: %s", hook, err)
+	}
+	return resp, nil
+}
+
+// handleHookResponse reports the warnings and modified files of a hook
+// response, and runs the actions it asks for
+func handleHookResponse(hook, env string, opts *HookOptions, resp *HookResponse) error {
+	for _, w := range resp.Warnings {
+		Log("warning: %s hook: %s", hook, w)
+	}
+
+	if len(resp.ModifiedFiles) > 0 {
+		VLog("  - %s hook modified %s", hook, strings.Join(resp.ModifiedFiles, ", "))
+	}
+
+	for _, a := range resp.Actions {
+		switch a.Type {
+		case HookActionRunHook:
+			if a.Hook == "" || a.Hook == hook {
+				return fmt.Errorf("%s hook asked to run invalid hook %q", hook, a.Hook)
+			}
+
+			VLog("  - running %s hook, as asked by %s hook", a.Hook, hook)
+			resp, err := runHook(a.Hook, env, false, opts, a.Args)
+			if err != nil {
+				return err
+			}
+
+			// follow up hooks can't chain further actions
+			if resp != nil {
+				resp.Actions = nil
+				if err := handleHookResponse(a.Hook, env, opts, resp); err != nil {
+					return err
+				}
+			}
+		default:
+			Log("warning: %s hook asked for unsupported action %q", hook, a.Type)
+		}
+	}
+	return nil
+}
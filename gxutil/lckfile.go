@@ -4,9 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+
+	cid "github.com/ipfs/go-cid"
+	log "github.com/whyrusleeping/stump"
 )
 
-const LockVersion = 1
+// LockVersion is the lockfile format written by gx. Version 1 files, which
+// only carry refs, can still be read.
+const LockVersion = 2
 
 type LockFile struct {
 	Lock
@@ -15,8 +22,15 @@ type LockFile struct {
 
 type Lock struct {
 	Language string `json:"language,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Version  string `json:"version,omitempty"`
+
+	Ref string `json:"ref,omitempty"`
+
+	// Digest is the hash of the package directory referenced by Ref, used
+	// to verify fetched content. Only present in version 2 lockfiles.
+	Digest string `json:"digest,omitempty"`
 
-	Ref  string                     `json:"ref,omitempty"`
 	Deps map[string]map[string]Lock `json:"deps,omitempty"`
 }
 
@@ -25,14 +39,239 @@ func LoadLockFile(lck *LockFile, fname string) error {
 	if err != nil {
 		return err
 	}
+	defer fi.Close()
 
 	if err := json.NewDecoder(fi).Decode(lck); err != nil {
 		return err
 	}
 
-	if lck.LockVersion != LockVersion {
+	switch lck.LockVersion {
+	case 1, LockVersion:
+	default:
 		return fmt.Errorf("unsupported lockfile version: %d", lck.LockVersion)
 	}
 
 	return nil
 }
+
+func SaveLockFile(lck *LockFile, fname string) error {
+	return writeJson(lck, fname)
+}
+
+// LockForPackage builds a lockfile pinning the full dependency tree of the
+// given package. All dependencies must already be installed.
+func (pm *PM) LockForPackage(pkg *Package) (*LockFile, error) {
+	deps, err := pm.lockDeps(pkg, make(map[string]Lock), make(map[string]string))
+	if err != nil {
+		return nil, err
+	}
+
+	return &LockFile{
+		Lock: Lock{
+			Language: pkg.Language,
+			Name:     pkg.Name,
+			Version:  pkg.Version,
+			Deps:     deps,
+		},
+		LockVersion: LockVersion,
+	}, nil
+}
+
+// lockDeps returns the lock entries for the dependencies of pkg, grouped by
+// language. 'done' memoizes entries by hash, and 'refs' tracks the ref each
+// install path was assigned so conflicts can be reported.
+func (pm *PM) lockDeps(pkg *Package, done map[string]Lock, refs map[string]string) (map[string]map[string]Lock, error) {
+	if len(pkg.Dependencies) == 0 {
+		return nil, nil
+	}
+
+	out := make(map[string]map[string]Lock)
+	err := pkg.ForEachDep(func(dep *Dependency, hash string, dpkg *Package) error {
+		key := dpkg.LockKey()
+		lck, ok := done[hash]
+		if !ok {
+			deps, err := pm.lockDeps(dpkg, done, refs)
+			if err != nil {
+				return err
+			}
+
+			ref := "/ipfs/" + hash + "/" + dpkg.Name
+			dir, err := PackageDir(pkg.Language, hash)
+			if err != nil {
+				dir = ""
+			}
+
+			digest, err := pm.lockDigest(ref, dir)
+			if err != nil {
+				return err
+			}
+
+			lck = Lock{
+				Language: dpkg.Language,
+				Name:     dpkg.Name,
+				Version:  dpkg.Version,
+				Ref:      ref,
+				Digest:   digest,
+				Deps:     deps,
+			}
+			done[hash] = lck
+		}
+
+		if prev, ok := refs[key]; ok && prev != lck.Ref {
+			log.Log("warning: %s is locked as both %s and %s", key, prev, lck.Ref)
+		}
+		refs[key] = lck.Ref
+
+		if out[dpkg.Language] == nil {
+			out[dpkg.Language] = make(map[string]Lock)
+		}
+		out[dpkg.Language][key] = lck
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// MigrateLock upgrades a version 1 lockfile to the current format, filling
+// in package information by fetching each ref into the lockfile cache in cwd.
+func (pm *PM) MigrateLock(lck *LockFile, cwd string) error {
+	if lck.LockVersion == LockVersion {
+		return nil
+	}
+
+	deps, err := pm.migrateLockDeps(lck.Deps, cwd)
+	if err != nil {
+		return err
+	}
+
+	lck.Deps = deps
+	lck.LockVersion = LockVersion
+	return nil
+}
+
+func (pm *PM) migrateLockDeps(deps map[string]map[string]Lock, cwd string) (map[string]map[string]Lock, error) {
+	if deps == nil {
+		return nil, nil
+	}
+
+	out := make(map[string]map[string]Lock)
+	for lang, langdeps := range deps {
+		out[lang] = make(map[string]Lock)
+		for key, lck := range langdeps {
+			cacheloc := filepath.Join(cwd, ".gx", "cache", lck.Ref)
+			if err := pm.tryFetch(lck.Ref, cacheloc); err != nil {
+				return nil, fmt.Errorf("fetching %s: %s", lck.Ref, err)
+			}
+
+			var pkg Package
+			if err := LoadPackageFile(&pkg, filepath.Join(cacheloc, PkgFileName)); err != nil {
+				return nil, err
+			}
+
+			digest, err := pm.lockDigest(lck.Ref, cacheloc)
+			if err != nil {
+				return nil, err
+			}
+
+			children, err := pm.migrateLockDeps(lck.Deps, cwd)
+			if err != nil {
+				return nil, err
+			}
+
+			lck.Language = lang
+			lck.Name = pkg.Name
+			lck.Version = pkg.Version
+			lck.Digest = digest
+			lck.Deps = children
+			out[lang][key] = lck
+		}
+	}
+
+	return out, nil
+}
+
+// lockDigest returns the digest of the package directory at ref, which is
+// hashed locally from its copy at dir when possible. The content store is
+// only asked when there is no copy, or it no longer matches the hash in ref,
+// e.g. because a post-install hook rewrote it.
+func (pm *PM) lockDigest(ref, dir string) (string, error) {
+	if dir != "" {
+		digest, err := localDigest(ref, dir)
+		if err == nil {
+			return digest, nil
+		}
+		log.VLog("  - cannot compute digest of %s locally: %s", ref, err)
+	}
+
+	digest, err := pm.Store().Resolve(ref)
+	if err != nil {
+		return "", fmt.Errorf("resolving %s: %s", ref, err)
+	}
+	return digest, nil
+}
+
+// localDigest hashes the copy at dir of the package directory at ref, which
+// must be of the form /ipfs/<hash>/<name>. The result is only trusted if a
+// directory holding just the copy as <name> hashes to <hash>. Only
+// directories are handled, as packages always are one.
+func localDigest(ref, dir string) (string, error) {
+	if !strings.HasPrefix(ref, "/ipfs/") {
+		return "", fmt.Errorf("unsupported ref")
+	}
+
+	parts := strings.Split(strings.TrimPrefix(ref, "/ipfs/"), "/")
+	if len(parts) != 2 {
+		return "", fmt.Errorf("unsupported ref")
+	}
+	hash, name := parts[0], parts[1]
+
+	exp, err := cid.Decode(hash)
+	if err != nil {
+		return "", err
+	}
+
+	formats, err := formatsFor(hash)
+	if err != nil {
+		return "", err
+	}
+	if len(formats) == 0 {
+		return "", fmt.Errorf("unsupported hash format")
+	}
+
+	if _, err := os.Stat(dir); err != nil {
+		return "", err
+	}
+
+	// the .gx directory is never published, but installing a package
+	// leaves hook markers in it
+	skip := func(name string) bool {
+		return name == ".gx"
+	}
+
+	for _, f := range formats {
+		digest, size, err := buildDir(dir, f, nil, skip)
+		if err != nil {
+			return "", err
+		}
+
+		root := emptyDirNode()
+		root.Links = []dagLink{{Name: name, Hash: digest, Size: size}}
+		rhash, _, err := putNode(root, f, nil)
+		if err != nil {
+			return "", err
+		}
+
+		c, err := cid.Decode(rhash)
+		if err != nil {
+			return "", err
+		}
+		if c.Equals(exp) {
+			return digest, nil
+		}
+	}
+
+	return "", fmt.Errorf("content does not match %s", hash)
+}
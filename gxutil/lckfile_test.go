@@ -0,0 +1,150 @@
+package gxutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lck := &LockFile{
+		Lock: Lock{
+			Language: "go",
+			Name:     "root",
+			Version:  "1.0.0",
+			Deps: map[string]map[string]Lock{
+				"go": {
+					"dep": {
+						Language: "go",
+						Name:     "dep",
+						Version:  "0.1.0",
+						Ref:      "/ipfs/QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o/dep",
+						Digest:   "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH",
+					},
+				},
+			},
+		},
+		LockVersion: LockVersion,
+	}
+
+	fname := filepath.Join(dir, LckFileName)
+	if err := SaveLockFile(lck, fname); err != nil {
+		t.Fatal(err)
+	}
+
+	var out LockFile
+	if err := LoadLockFile(&out, fname); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&out, lck) {
+		t.Fatalf("lockfile did not survive a round trip: %+v", out)
+	}
+
+	v1 := `{"lockVersion": 1, "deps": {"go": {"dep": {"ref": "/ipfs/QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o/dep"}}}}`
+	if err := ioutil.WriteFile(fname, []byte(v1), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	out = LockFile{}
+	if err := LoadLockFile(&out, fname); err != nil {
+		t.Fatalf("version 1 lockfile: %s", err)
+	}
+	if out.Deps["go"]["dep"].Digest != "" {
+		t.Fatal("version 1 lockfile has a digest")
+	}
+
+	if err := ioutil.WriteFile(fname, []byte(`{"lockVersion": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := LoadLockFile(&out, fname); err == nil {
+		t.Fatal("expected an error for an unknown lockfile version")
+	}
+}
+
+func TestLockDigest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkgdir := filepath.Join(dir, "hash", "dep")
+	if err := os.MkdirAll(pkgdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pkgdir, PkgFileName), []byte(`{"name": "dep"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := &PM{store: s}
+
+	for name, f := range map[string]dagFormat{"cidv0": defaultFormat, "cidv1": cidV1Format} {
+		root, err := hashPath(filepath.Dir(pkgdir), f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected, err := hashPath(pkgdir, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// installing leaves hook markers behind, which are not content
+		if err := writePkgHook(pkgdir, "post-install"); err != nil {
+			t.Fatal(err)
+		}
+
+		// the store is empty, so this must not need it
+		ref := "/ipfs/" + root + "/dep"
+		digest, err := pm.lockDigest(ref, pkgdir)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+		if err := os.RemoveAll(filepath.Join(pkgdir, ".gx")); err != nil {
+			t.Fatal(err)
+		}
+		if digest != expected {
+			t.Fatalf("%s: expected digest %s, got %s", name, expected, digest)
+		}
+
+		if _, err := pm.lockDigest(ref, ""); err == nil {
+			t.Fatalf("%s: expected an error without a local copy or the package in the store", name)
+		}
+	}
+
+	root, _, err := buildPath(filepath.Dir(pkgdir), defaultFormat, s.put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := hashPath(pkgdir, defaultFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a copy changed after it was fetched is not trusted
+	if err := ioutil.WriteFile(filepath.Join(pkgdir, "rewritten"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := localDigest("/ipfs/"+root+"/dep", pkgdir); err == nil {
+		t.Fatal("expected modified copy to be refused")
+	}
+
+	digest, err := pm.lockDigest("/ipfs/"+root+"/dep", pkgdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest != expected {
+		t.Fatalf("expected digest %s from the store, got %s", expected, digest)
+	}
+}
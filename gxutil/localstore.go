@@ -0,0 +1,373 @@
+package gxutil
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStore is a ContentStore kept in a directory on the local filesystem.
+// Objects are stored as dag-pb blocks under their hash, so packages
+// published to a LocalStore get the same hashes they would on ipfs.
+type LocalStore struct {
+	root string
+}
+
+func NewLocalStore(root string) (*LocalStore, error) {
+	for _, d := range []string{"blocks", "names"} {
+		if err := os.MkdirAll(filepath.Join(root, d), 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	return &LocalStore{root: root}, nil
+}
+
+func (s *LocalStore) blockPath(hash string) string {
+	return filepath.Join(s.root, "blocks", hash)
+}
+
+func (s *LocalStore) put(hash string, data []byte) error {
+	p := s.blockPath(hash)
+	if _, err := os.Stat(p); err == nil {
+		return nil
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(p), hash+".part")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *LocalStore) block(hash string) ([]byte, error) {
+	data, err := ioutil.ReadFile(s.blockPath(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("object %s not found in local store %s", hash, s.root)
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func (s *LocalStore) node(hash string) (*dagNode, error) {
+	data, err := s.block(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if isRawBlock(hash) {
+		// raw blocks have no links, and no unixfs data to go with them
+		return &dagNode{}, nil
+	}
+
+	return unmarshalDagNode(data)
+}
+
+func (s *LocalStore) nodeSize(hash string) (uint64, error) {
+	fi, err := os.Stat(s.blockPath(hash))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, fmt.Errorf("object %s not found in local store %s", hash, s.root)
+		}
+		return 0, err
+	}
+
+	if isRawBlock(hash) {
+		return uint64(fi.Size()), nil
+	}
+
+	nd, err := s.node(hash)
+	if err != nil {
+		return 0, err
+	}
+
+	size := uint64(fi.Size())
+	for _, l := range nd.Links {
+		size += l.Size
+	}
+	return size, nil
+}
+
+func (s *LocalStore) resolvePath(p string) (string, error) {
+	p = strings.TrimPrefix(p, "/ipfs/")
+	parts := strings.Split(strings.Trim(p, "/"), "/")
+
+	cur := parts[0]
+	for _, name := range parts[1:] {
+		nd, err := s.node(cur)
+		if err != nil {
+			return "", err
+		}
+
+		next := ""
+		for _, l := range nd.Links {
+			if l.Name == name {
+				next = l.Hash
+				break
+			}
+		}
+		if next == "" {
+			return "", fmt.Errorf("no link named %q under %s", name, cur)
+		}
+		cur = next
+	}
+
+	return cur, nil
+}
+
+func (s *LocalStore) Get(hash, outdir string) error {
+	h, err := s.resolvePath(hash)
+	if err != nil {
+		return err
+	}
+
+	return s.writeTo(h, outdir)
+}
+
+func (s *LocalStore) writeTo(hash, out string) error {
+	if isRawBlock(hash) {
+		data, err := s.block(hash)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(out, data, 0644)
+	}
+
+	nd, err := s.node(hash)
+	if err != nil {
+		return err
+	}
+
+	ufs, err := unmarshalUnixfsData(nd.Data)
+	if err != nil {
+		return err
+	}
+
+	switch ufs.Type {
+	case unixfsDirectory:
+		if err := os.MkdirAll(out, 0755); err != nil {
+			return err
+		}
+
+		for _, l := range nd.Links {
+			if err := s.writeTo(l.Hash, filepath.Join(out, l.Name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case unixfsSymlink:
+		return os.Symlink(string(ufs.Data), out)
+	case unixfsFile, unixfsRaw:
+		fi, err := os.Create(out)
+		if err != nil {
+			return err
+		}
+
+		if err := s.writeFileData(nd, ufs, fi); err != nil {
+			fi.Close()
+			return err
+		}
+		return fi.Close()
+	default:
+		return fmt.Errorf("unsupported unixfs type %d in %s", ufs.Type, hash)
+	}
+}
+
+func (s *LocalStore) writeFileData(nd *dagNode, ufs *unixfsData, w io.Writer) error {
+	if _, err := w.Write(ufs.Data); err != nil {
+		return err
+	}
+
+	for _, l := range nd.Links {
+		if isRawBlock(l.Hash) {
+			data, err := s.block(l.Hash)
+			if err != nil {
+				return err
+			}
+
+			if _, err := w.Write(data); err != nil {
+				return err
+			}
+			continue
+		}
+
+		child, err := s.node(l.Hash)
+		if err != nil {
+			return err
+		}
+
+		cufs, err := unmarshalUnixfsData(child.Data)
+		if err != nil {
+			return err
+		}
+
+		if err := s.writeFileData(child, cufs, w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *LocalStore) List(path string) ([]*Link, error) {
+	h, err := s.resolvePath(path)
+	if err != nil {
+		return nil, err
+	}
+
+	nd, err := s.node(h)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Link, 0, len(nd.Links))
+	for _, l := range nd.Links {
+		out = append(out, &Link{
+			Name: l.Name,
+			Hash: l.Hash,
+			Size: l.Size,
+		})
+	}
+	return out, nil
+}
+
+func (s *LocalStore) NewDir() (string, error) {
+	hash, _, err := putNode(emptyDirNode(), defaultFormat, s.put)
+	return hash, err
+}
+
+func (s *LocalStore) AddFile(r io.Reader) (string, error) {
+	hash, _, err := buildFile(r, defaultFormat, s.put)
+	return hash, err
+}
+
+func (s *LocalStore) AddLink(target string) (string, error) {
+	hash, _, err := putNode(symlinkNode(target), defaultFormat, s.put)
+	return hash, err
+}
+
+func (s *LocalStore) PatchLink(root, name, child string, create bool) (string, error) {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	return s.patchLink(root, parts, child, create)
+}
+
+func (s *LocalStore) patchLink(root string, path []string, child string, create bool) (string, error) {
+	nd, err := s.node(root)
+	if err != nil {
+		return "", err
+	}
+
+	name := path[0]
+	var links []dagLink
+	var existing string
+	for _, l := range nd.Links {
+		if l.Name == name {
+			existing = l.Hash
+			continue
+		}
+		links = append(links, l)
+	}
+
+	target := child
+	if len(path) > 1 {
+		if existing == "" {
+			if !create {
+				return "", fmt.Errorf("no link named %q under %s", name, root)
+			}
+
+			existing, err = s.NewDir()
+			if err != nil {
+				return "", err
+			}
+		}
+
+		target, err = s.patchLink(existing, path[1:], child, create)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	size, err := s.nodeSize(target)
+	if err != nil {
+		return "", err
+	}
+
+	nd.Links = append(links, dagLink{Name: name, Hash: target, Size: size})
+	hash, _, err := putNode(nd, defaultFormat, s.put)
+	return hash, err
+}
+
+func (s *LocalStore) namePath(name string) string {
+	return filepath.Join(s.root, "names", name)
+}
+
+func (s *LocalStore) Resolve(name string) (string, error) {
+	if !strings.HasPrefix(name, "/ipns/") {
+		return s.resolvePath(name)
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(name, "/ipns/"), "/", 2)
+	val, err := ioutil.ReadFile(s.namePath(parts[0]))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("name %s not found in local store %s", parts[0], s.root)
+		}
+		return "", err
+	}
+
+	p := string(bytes.TrimSpace(val))
+	if len(parts) > 1 {
+		p += "/" + parts[1]
+	}
+
+	return s.Resolve(p)
+}
+
+func (s *LocalStore) Pin(hash string) error {
+	h, err := s.resolvePath(hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.node(h)
+	return err
+}
+
+func (s *LocalStore) Publish(name, hash string) error {
+	if name == "" || strings.ContainsAny(name, "/\\") {
+		return fmt.Errorf("invalid name: %q", name)
+	}
+
+	h, err := s.resolvePath(hash)
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.node(h); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(s.namePath(name), []byte(h+"\n"), 0644)
+}
+
+func (s *LocalStore) Online() bool {
+	return true
+}
@@ -0,0 +1,115 @@
+package gxutil
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStoreRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-localstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	bigfile := pseudoRandom(3*chunkSize+100, 7)
+	src := filepath.Join(dir, "src")
+	files := map[string][]byte{
+		"small":        []byte("hello world\n"),
+		"empty":        nil,
+		"sub/big":      bigfile,
+		"sub/deeper/x": []byte("x"),
+	}
+	for name, content := range files {
+		p := filepath.Join(src, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("sub/big", filepath.Join(src, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	formats := map[string]dagFormat{
+		"default": defaultFormat,
+		"legacy":  legacyFormat,
+		"cidv1":   cidV1Format,
+	}
+	for name, f := range formats {
+		hash, _, err := buildPath(src, f, s.put)
+		if err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		out := filepath.Join(dir, "out-"+name)
+		if err := s.Get(hash, out); err != nil {
+			t.Fatalf("%s: %s", name, err)
+		}
+
+		for fname, content := range files {
+			data, err := ioutil.ReadFile(filepath.Join(out, fname))
+			if err != nil {
+				t.Fatalf("%s: %s", name, err)
+			}
+			if !bytes.Equal(data, content) {
+				t.Errorf("%s: %s came back with different contents", name, fname)
+			}
+		}
+
+		target, err := os.Readlink(filepath.Join(out, "link"))
+		if err != nil || target != "sub/big" {
+			t.Errorf("%s: symlink came back as %q (%v)", name, target, err)
+		}
+
+		again, err := hashPath(out, f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if again != hash {
+			t.Errorf("%s: fetched tree hashes to %s, not %s", name, again, hash)
+		}
+	}
+}
+
+func TestLocalStoreRawFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-localstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a single chunk file with raw leaves is a raw block itself
+	hash, _, err := buildFile(bytes.NewReader([]byte("hello world\n")), cidV1Format, s.put)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out := filepath.Join(dir, "out")
+	if err := s.Get(hash, out); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "hello world\n" {
+		t.Fatalf("got %q", data)
+	}
+}
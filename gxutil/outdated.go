@@ -0,0 +1,169 @@
+package gxutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/blang/semver"
+)
+
+// Semver deltas between the version of a dependency in use and the latest
+// published one.
+const (
+	DeltaNone       = "none"
+	DeltaPatch      = "patch"
+	DeltaMinor      = "minor"
+	DeltaMajor      = "major"
+	DeltaPrerelease = "prerelease"
+	DeltaDowngrade  = "downgrade"
+	DeltaHash       = "hash"
+	DeltaUnknown    = "unknown"
+)
+
+// OutdatedDep compares a dependency against the latest version of it that
+// could be resolved.
+type OutdatedDep struct {
+	Name        string `json:"name"`
+	Importer    string `json:"importer"`
+	Current     string `json:"current"`
+	CurrentHash string `json:"currentHash"`
+	Latest      string `json:"latest,omitempty"`
+	LatestHash  string `json:"latestHash,omitempty"`
+	Delta       string `json:"delta"`
+	Source      string `json:"source,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Outdated reports whether a newer version of the dependency was found
+func (od *OutdatedDep) Outdated() bool {
+	switch od.Delta {
+	case DeltaPatch, DeltaMinor, DeltaMajor, DeltaPrerelease, DeltaHash:
+		return true
+	default:
+		return false
+	}
+}
+
+// CheckOutdated looks up the latest published version of each dependency of
+// pkg. Dependencies with a dvcsimport on a known forge are resolved through
+// their .gx/lastpubver, all others through the configured repos. If
+// recursive is set, the dependencies of those dependencies are checked too.
+func (pm *PM) CheckOutdated(pkg *Package, recursive bool) ([]*OutdatedDep, error) {
+	tmpdir, err := ioutil.TempDir("", "gx-outdated")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var out []*OutdatedDep
+	done := make(map[string]bool)
+	err = pm.checkOutdatedRec(pkg, recursive, tmpdir, done, &out)
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (pm *PM) checkOutdatedRec(pkg *Package, recursive bool, tmpdir string, done map[string]bool, out *[]*OutdatedDep) error {
+	for _, dep := range pkg.Dependencies {
+		if done[dep.Hash] {
+			continue
+		}
+		done[dep.Hash] = true
+
+		od := &OutdatedDep{
+			Name:        dep.Name,
+			Importer:    pkg.Name,
+			Current:     dep.Version,
+			CurrentHash: dep.Hash,
+		}
+		*out = append(*out, od)
+
+		var dpkg Package
+		err := LoadPackage(&dpkg, pkg.Language, dep.Hash)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return err
+			}
+
+			// without the package we can only go by the name
+			dpkg.Name = dep.Name
+		} else if dpkg.Version != "" {
+			od.Current = dpkg.Version
+		}
+
+		if err := pm.resolveLatest(od, &dpkg, tmpdir); err != nil {
+			od.Delta = DeltaUnknown
+			od.Error = err.Error()
+		}
+
+		if recursive && dpkg.Language != "" {
+			if err := pm.checkOutdatedRec(&dpkg, recursive, tmpdir, done, out); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (pm *PM) resolveLatest(od *OutdatedDep, dpkg *Package, tmpdir string) error {
+	var err error
+	if fr, ok := pm.ParseForgeRef(dpkg.DvcsImport()); ok {
+		od.Source = fr.String()
+		od.LatestHash, err = pm.resolveForgeDep(fr)
+	} else {
+		od.Source = "repos"
+		od.LatestHash, err = pm.resolveNameInRepos(dpkg.Name)
+	}
+	if err != nil {
+		return err
+	}
+
+	if od.LatestHash == od.CurrentHash {
+		od.Latest = od.Current
+		od.Delta = DeltaNone
+		return nil
+	}
+
+	latest, err := pm.GetPackageTo(od.LatestHash, filepath.Join(tmpdir, od.LatestHash))
+	if err != nil {
+		return fmt.Errorf("fetching %s: %s", od.LatestHash, err)
+	}
+
+	od.Latest = latest.Version
+	od.Delta = SemverDelta(od.Current, od.Latest)
+	return nil
+}
+
+// SemverDelta describes how the version to differs from the version from
+func SemverDelta(from, to string) string {
+	fv, err := semver.Make(from)
+	if err != nil {
+		return DeltaUnknown
+	}
+
+	tv, err := semver.Make(to)
+	if err != nil {
+		return DeltaUnknown
+	}
+
+	switch {
+	case tv.LT(fv):
+		return DeltaDowngrade
+	case tv.Major != fv.Major:
+		return DeltaMajor
+	case tv.Minor != fv.Minor:
+		return DeltaMinor
+	case tv.Patch != fv.Patch:
+		return DeltaPatch
+	case tv.GT(fv):
+		return DeltaPrerelease
+	default:
+		// published again under the same version
+		return DeltaHash
+	}
+}
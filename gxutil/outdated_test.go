@@ -0,0 +1,38 @@
+package gxutil
+
+import "testing"
+
+func TestSemverDelta(t *testing.T) {
+	cases := []struct {
+		from, to, delta string
+	}{
+		{"1.2.3", "1.2.3", DeltaHash},
+		{"1.2.3", "1.2.4", DeltaPatch},
+		{"1.2.3", "1.3.0", DeltaMinor},
+		{"1.2.3", "2.0.0", DeltaMajor},
+		{"1.2.3-rc1", "1.2.3", DeltaPrerelease},
+		{"1.2.3", "1.2.2", DeltaDowngrade},
+		{"1.2.3", "not-a-version", DeltaUnknown},
+		{"", "1.0.0", DeltaUnknown},
+	}
+
+	for _, c := range cases {
+		if d := SemverDelta(c.from, c.to); d != c.delta {
+			t.Errorf("%s -> %s: expected %s, got %s", c.from, c.to, c.delta, d)
+		}
+	}
+
+	outdated := map[string]bool{
+		DeltaNone:      false,
+		DeltaPatch:     true,
+		DeltaHash:      true,
+		DeltaDowngrade: false,
+		DeltaUnknown:   false,
+	}
+	for delta, exp := range outdated {
+		od := &OutdatedDep{Delta: delta}
+		if od.Outdated() != exp {
+			t.Errorf("%s: expected outdated to be %t", delta, exp)
+		}
+	}
+}
@@ -6,26 +6,31 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"reflect"
+	"strings"
 
 	log "github.com/whyrusleeping/stump"
 )
 
 type PackageBase struct {
-	Name            string        `json:"name,omitempty"`
-	Author          string        `json:"author,omitempty"`
-	Description     string        `json:"description,omitempty"`
-	Keywords        []string      `json:"keywords,omitempty"`
-	Version         string        `json:"version,omitempty"`
-	Dependencies    []*Dependency `json:"gxDependencies,omitempty"`
-	Bin             string        `json:"bin,omitempty"`
-	Build           string        `json:"build,omitempty"`
-	Test            string        `json:"test,omitempty"`
-	ReleaseCmd      string        `json:"releaseCmd,omitempty"`
-	SubtoolRequired bool          `json:"subtoolRequired,omitempty"`
-	Language        string        `json:"language,omitempty"`
-	License         string        `json:"license"`
-	Bugs            BugsObj       `json:"bugs"`
-	GxVersion       string        `json:"gxVersion"`
+	Name            string              `json:"name,omitempty"`
+	Author          string              `json:"author,omitempty"`
+	Description     string              `json:"description,omitempty"`
+	Keywords        []string            `json:"keywords,omitempty"`
+	Version         string              `json:"version,omitempty"`
+	Dependencies    []*Dependency       `json:"gxDependencies,omitempty"`
+	Overrides       Overrides           `json:"gxOverrides,omitempty"`
+	Bin             string              `json:"bin,omitempty"`
+	Build           string              `json:"build,omitempty"`
+	Test            string              `json:"test,omitempty"`
+	Scripts         map[string]string   `json:"scripts,omitempty"`
+	ReleaseCmd      string              `json:"releaseCmd,omitempty"`
+	Hooks           map[string]*PkgHook `json:"gxHooks,omitempty"`
+	SubtoolRequired bool                `json:"subtoolRequired,omitempty"`
+	Language        string              `json:"language,omitempty"`
+	License         string              `json:"license"`
+	Bugs            BugsObj             `json:"bugs"`
+	GxVersion       string              `json:"gxVersion"`
 }
 
 type BugsObj struct {
@@ -44,6 +49,10 @@ type Dependency struct {
 	Name    string `json:"name,omitempty"`
 	Hash    string `json:"hash"`
 	Version string `json:"version,omitempty"`
+
+	// Range optionally constrains the versions 'gx update --within-range'
+	// may move this dependency to, e.g. "^1.2.0"
+	Range string `json:"range,omitempty"`
 }
 
 func LoadPackageFile(pkg interface{}, fname string) error {
@@ -97,9 +106,48 @@ func SavePackageFile(pkg interface{}, fname string) error {
 		return err
 	}
 
+	// fields emptied out on pkg are left out of its encoding, so they need
+	// to be removed explicitly to not survive the merge
+	for _, k := range omittedFields(pkg, modified) {
+		delete(current, k)
+	}
+
 	return writeJson(mergeMaps(current, modified), fname)
 }
 
+// omittedFields returns the json keys of the struct pkg points to that are
+// missing from its encoding
+func omittedFields(pkg interface{}, encoded map[string]interface{}) []string {
+	v := reflect.Indirect(reflect.ValueOf(pkg))
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var out []string
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+
+		if f.Anonymous {
+			out = append(out, omittedFields(v.Field(i).Interface(), encoded)...)
+			continue
+		}
+
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" || name == "-" {
+			continue
+		}
+
+		if _, ok := encoded[name]; !ok {
+			out = append(out, name)
+		}
+	}
+	return out
+}
+
 func writeJson(i interface{}, fname string) error {
 	fi, err := os.Create(fname)
 	if err != nil {
@@ -124,6 +172,28 @@ func writeJson(i interface{}, fname string) error {
 	return err
 }
 
+// Overrides maps package names or hashes to the hash that should be used in
+// their place, anywhere in the dependency tree. Only the overrides of the
+// root package are honored.
+type Overrides map[string]string
+
+// Apply returns dep, or a copy of it pointing at the overriding hash if one
+// matches it. Overrides by hash take precedence over overrides by name.
+func (o Overrides) Apply(dep *Dependency) *Dependency {
+	to, ok := o[dep.Hash]
+	if !ok {
+		to, ok = o[dep.Name]
+	}
+	if !ok || to == dep.Hash {
+		return dep
+	}
+
+	log.VLog("  - overriding %s (%s) with %s", dep.Name, dep.Hash, to)
+	nd := *dep
+	nd.Hash = to
+	return &nd
+}
+
 // FindDep returns a reference to the named dependency in this package file
 func (pkg *PackageBase) FindDep(ref string) *Dependency {
 	for _, d := range pkg.Dependencies {
@@ -134,20 +204,29 @@ func (pkg *PackageBase) FindDep(ref string) *Dependency {
 	return nil
 }
 
-func (pkg *PackageBase) ForEachDep(cb func(dep *Dependency, pkg *Package) error) error {
+// ForEachDep calls cb with every dependency of pkg, the hash it resolves to
+// after applying gxOverrides, and the installed package at that hash. dep is
+// the entry of pkg itself, so changes made to it are kept, even when hash
+// differs from dep.Hash because of an override.
+func (pkg *PackageBase) ForEachDep(cb func(dep *Dependency, hash string, pkg *Package) error) error {
 	log.VLog("  - foreachdep: %s", pkg.Name)
 	for _, dep := range pkg.Dependencies {
+		hash := pkg.Overrides.Apply(dep).Hash
+
 		var cpkg Package
-		err := LoadPackage(&cpkg, pkg.Language, dep.Hash)
+		err := LoadPackage(&cpkg, pkg.Language, hash)
 		if err != nil {
 			if os.IsNotExist(err) {
 				log.VLog("LoadPackage error: ", err)
-				return fmt.Errorf("package %s (%s) not found", dep.Name, dep.Hash)
+				return fmt.Errorf("package %s (%s) not found", dep.Name, hash)
 			}
 			return err
 		}
 
-		err = cb(dep, &cpkg)
+		// the root package controls the whole tree
+		cpkg.Overrides = pkg.Overrides
+
+		err = cb(dep, hash, &cpkg)
 		if err != nil {
 			return err
 		}
@@ -155,3 +234,27 @@ func (pkg *PackageBase) ForEachDep(cb func(dep *Dependency, pkg *Package) error)
 
 	return nil
 }
+
+// DvcsImport returns the 'gx.dvcsimport' path of this package, if it has one
+func (pkg *Package) DvcsImport() string {
+	if len(pkg.Gx) == 0 {
+		return ""
+	}
+
+	var gxinfo struct {
+		DvcsImport string `json:"dvcsimport"`
+	}
+	if err := json.Unmarshal(pkg.Gx, &gxinfo); err != nil {
+		return ""
+	}
+
+	return gxinfo.DvcsImport
+}
+
+// LockKey returns the path this package is installed under by a lockfile
+func (pkg *Package) LockKey() string {
+	if imp := pkg.DvcsImport(); imp != "" {
+		return imp
+	}
+	return pkg.Name
+}
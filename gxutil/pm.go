@@ -1,10 +1,9 @@
 package gxutil
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"os"
 	"os/exec"
 	"os/user"
@@ -14,6 +13,7 @@ import (
 	"sync"
 	"time"
 
+	cid "github.com/ipfs/go-cid"
 	sh "github.com/ipfs/go-ipfs-api"
 	mh "github.com/multiformats/go-multihash"
 	prog "github.com/whyrusleeping/progmeter"
@@ -37,6 +37,7 @@ func init() {
 }
 
 type PM struct {
+	store  ContentStore
 	ipfssh *sh.Shell
 
 	cfg *Config
@@ -50,11 +51,18 @@ type PM struct {
 }
 
 func NewPM(cfg *Config) (*PM, error) {
-	sh := NewShell()
-	sh.SetTimeout(time.Minute * 8)
+	store, err := NewContentStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	if ss, ok := store.(*ShellStore); ok {
+		ss.Shell().SetTimeout(time.Minute * 8)
+	}
+
 	return &PM{
-		ipfssh: sh,
-		cfg:    cfg,
+		store: store,
+		cfg:   cfg,
 	}, nil
 }
 
@@ -79,7 +87,22 @@ func GetPackageRoot() (string, error) {
 	return "", fmt.Errorf("no package found in this directory or any above")
 }
 
+// Store returns the content store packages are fetched from and published to
+func (pm *PM) Store() ContentStore {
+	if pm.store == nil {
+		pm.store = NewShellStore(pm.Shell())
+	}
+
+	return pm.store
+}
+
+// Shell returns an ipfs api shell, regardless of the configured content
+// store. Use Store for anything that should work with other backends.
 func (pm *PM) Shell() *sh.Shell {
+	if ss, ok := pm.store.(*ShellStore); ok {
+		return ss.Shell()
+	}
+
 	if pm.ipfssh == nil {
 		pm.ipfssh = NewShell()
 		pm.ipfssh.SetTimeout(time.Minute * 8)
@@ -89,24 +112,48 @@ func (pm *PM) Shell() *sh.Shell {
 }
 
 func (pm *PM) ShellOnline() bool {
-	_, err := pm.Shell().ID()
-	return err == nil
+	return pm.Store().Online()
 }
 
 func (pm *PM) SetGlobal(g bool) {
 	pm.global = g
 }
 
-func maybeRunPostInstall(pkg *Package, pkgdir string, global bool) error {
+// DepHooksAllowed reports whether the gxHooks scripts of dependencies are
+// run, which the allow_dep_hooks config option turns on
+func (pm *PM) DepHooksAllowed() bool {
+	return pm.cfg != nil && pm.cfg.AllowDepHooks
+}
+
+func (pm *PM) maybeRunPostInstall(pkg *Package, pkgdir string) error {
 	dir := filepath.Join(pkgdir, pkg.Name)
 	if !pkgRanHook(dir, "post-install") {
 		before := time.Now()
 		VLog("  - running post install for %s:", pkg.Name, pkgdir)
 		args := []string{pkgdir}
-		if global {
+		if pm.global {
 			args = append(args, "--global")
 		}
-		err := TryRunHook("post-install", pkg.Language, pkg.SubtoolRequired, args...)
+		var env []string
+		if len(pkg.Overrides) > 0 {
+			ov, err := json.Marshal(pkg.Overrides)
+			if err != nil {
+				return err
+			}
+			env = append(env, "GX_OVERRIDES="+string(ov))
+		}
+
+		opts := &HookOptions{
+			Pkg:         pkg,
+			Dir:         dir,
+			InstallPath: pkgdir,
+			Global:      pm.global,
+			Env:         env,
+
+			Dependency:    true,
+			AllowDepHooks: pm.DepHooksAllowed(),
+		}
+		err := TryRunHookOpts("post-install", pkg.Language, pkg.SubtoolRequired, opts, args...)
 		if err != nil {
 			return err
 		}
@@ -141,7 +188,7 @@ func (pm *PM) InstallPackage(hash, ipath string) (*Package, error) {
 		return nil, err
 	}
 
-	if err := maybeRunPostInstall(cpkg, pkgdir, pm.global); err != nil {
+	if err := pm.maybeRunPostInstall(cpkg, pkgdir); err != nil {
 		return nil, err
 	}
 
@@ -157,6 +204,7 @@ type DepWork struct {
 	LinkDir  string
 	Dep      string
 	Ref      string
+	Digest   string
 }
 
 // InstallLock recursively installs all dependencies for the given lockfile
@@ -179,7 +227,22 @@ func (pm *PM) InstallLock(lck Lock, cwd string) error {
 				cacheloc := filepath.Join(work.CacheDir, work.Ref)
 				linkloc := filepath.Join(work.LinkDir, work.Dep)
 
-				if err := pm.CacheAndLinkPackage(work.Ref, cacheloc, linkloc); err != nil {
+				err := pm.tryFetch(work.Ref, cacheloc)
+				if err == nil && work.Digest != "" && pm.verifyEnabled() {
+					if verr := verifyHash(cacheloc, work.Digest); verr != nil {
+						err = fmt.Errorf("verifying %s: %s", work.Ref, verr)
+
+						// don't leave bad content in the cache
+						if rmerr := os.RemoveAll(cacheloc); rmerr != nil {
+							Error("cleaning up %s: %s", cacheloc, rmerr)
+						}
+					}
+				}
+				if err == nil {
+					err = pm.CacheAndLinkPackage(work.Ref, cacheloc, linkloc)
+				}
+
+				if err != nil {
 					pm.ProgMeter.Error(work.Ref, err.Error())
 
 					lk.Lock()
@@ -233,6 +296,9 @@ func (pm *PM) installLock(lck Lock, cwd string, workers chan<- DepWork) ([]Lock,
 		if err != nil {
 			return []Lock{}, err
 		}
+		if !filepath.IsAbs(ipath) {
+			ipath = filepath.Join(cwd, ipath)
+		}
 
 		pm.ProgMeter.AddTodos(len(langdeps))
 
@@ -246,6 +312,7 @@ func (pm *PM) installLock(lck Lock, cwd string, workers chan<- DepWork) ([]Lock,
 				LinkDir:  ipath,
 				Dep:      dep,
 				Ref:      deplock.Ref,
+				Digest:   deplock.Digest,
 			}
 
 		}
@@ -327,7 +394,7 @@ func (pm *PM) InitPkg(dir, name, lang string, setup func(*Package)) error {
 		return err
 	}
 
-	err = TryRunHook("post-init", lang, pkg.SubtoolRequired, dir)
+	err = TryRunHookOpts("post-init", lang, pkg.SubtoolRequired, &HookOptions{Pkg: pkg, Dir: dir}, dir)
 	return err
 }
 
@@ -370,7 +437,7 @@ func (pm *PM) ImportPackage(dir, dephash string) (*Dependency, error) {
 		return nil, err
 	}
 
-	err = maybeRunPostInstall(ndep, pkgpath, pm.global)
+	err = pm.maybeRunPostInstall(ndep, pkgpath)
 	if err != nil {
 		return nil, err
 	}
@@ -382,7 +449,16 @@ func (pm *PM) ImportPackage(dir, dephash string) (*Dependency, error) {
 		}
 	}
 
-	err = TryRunHook("post-import", ndep.Language, ndep.SubtoolRequired, dephash)
+	opts := &HookOptions{
+		Pkg:         ndep,
+		Dir:         filepath.Join(pkgpath, ndep.Name),
+		InstallPath: dir,
+		Global:      pm.global,
+
+		Dependency:    true,
+		AllowDepHooks: pm.DepHooksAllowed(),
+	}
+	err = TryRunHookOpts("post-import", ndep.Language, ndep.SubtoolRequired, opts, dephash)
 	if err != nil {
 		return nil, err
 	}
@@ -395,50 +471,33 @@ func (pm *PM) ImportPackage(dir, dephash string) (*Dependency, error) {
 }
 
 // ResolveDepName resolves a given package name to a hash
-// using configured repos as a mapping.
+// using configured repos as a mapping. A specific version
+// can be asked for with name@version.
 func (pm *PM) ResolveDepName(name string) (string, error) {
-	_, err := mh.FromB58String(name)
-	if err == nil {
-		return name, nil
+	if c, err := cid.Decode(name); err == nil {
+		// use a canonical form so the same package always ends up in the
+		// same gx/ipfs/<hash> directory, whatever multibase it was given in
+		return c.String(), nil
 	}
 
-	if strings.HasPrefix(name, "github.com/") {
-		return pm.resolveGithubDep(name)
+	if _, err := mh.FromB58String(name); err == nil {
+		return name, nil
 	}
 
-	return pm.resolveNameInRepos(name)
-}
-
-func githubRawPath(repo string) string {
-	base := strings.Replace(repo, "github.com", "raw.githubusercontent.com", 1)
-	return base + "/master"
-}
-
-func (pm *PM) resolveGithubDep(name string) (string, error) {
-	resp, err := http.Get("https://" + githubRawPath(name) + "/.gx/lastpubver")
-	if err != nil {
-		return "", err
+	if fr, ok := pm.ParseForgeRef(name); ok {
+		return pm.resolveForgeDep(fr)
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case 200:
-		out, err := ioutil.ReadAll(resp.Body)
+	// name@version
+	if i := strings.LastIndex(name, "@"); i > 0 {
+		v, err := pm.ResolveVersion(name[:i], name[i+1:])
 		if err != nil {
 			return "", err
 		}
-
-		parts := strings.Split(string(out), ": ")
-		if len(parts) < 2 {
-			return "", fmt.Errorf("unrecognized format on .gx/lastpubver")
-		}
-		VLog("  - resolved %q to %s, version %s", name, parts[1], parts[0])
-		return strings.TrimSpace(parts[1]), nil
-	case 404:
-		return "", fmt.Errorf("no gx package found at %s", name)
-	default:
-		return "", fmt.Errorf("unrecognized http response from github: %d: %s", resp.StatusCode, resp.Status)
+		return v.Hash, nil
 	}
+
+	return pm.resolveNameInRepos(name)
 }
 
 func (pm *PM) resolveNameInRepos(name string) (string, error) {
@@ -462,7 +521,7 @@ func (pm *PM) resolveNameInRepos(name string) (string, error) {
 		return val, nil
 	}
 
-	out, err := pm.QueryRepos(name)
+	out, err := pm.QueryReposOrdered(name)
 	if err != nil {
 		return "", err
 	}
@@ -471,13 +530,14 @@ func (pm *PM) resolveNameInRepos(name string) (string, error) {
 		return "", fmt.Errorf("could not find package by name: %s", name)
 	}
 
-	if len(out) == 1 {
-		for _, v := range out {
-			return v, nil
+	best := out[0]
+	for _, m := range out[1:] {
+		if m.Hash != best.Hash {
+			VLog("  - %s is also in repo %s as %s, using repo %s (priority %d)", name, m.Name, m.Hash, best.Name, best.Priority)
 		}
 	}
 
-	return "", fmt.Errorf("ambiguous ref, appears in multiple repos")
+	return best.Hash, nil
 }
 
 func (pm *PM) EnumerateDependencies(pkg *Package) (map[string]string, error) {
@@ -492,6 +552,7 @@ func (pm *PM) EnumerateDependencies(pkg *Package) (map[string]string, error) {
 
 func (pm *PM) enumerateDepsRec(pkg *Package, set map[string]string) error {
 	for _, d := range pkg.Dependencies {
+		d = pkg.Overrides.Apply(d)
 		if _, ok := set[d.Hash]; ok {
 			continue
 		}
@@ -507,6 +568,7 @@ func (pm *PM) enumerateDepsRec(pkg *Package, set map[string]string) error {
 			return err
 		}
 
+		depkg.Overrides = pkg.Overrides
 		err = pm.enumerateDepsRec(&depkg, set)
 		if err != nil {
 			return err
@@ -559,15 +621,15 @@ func GetDepStats(pkg *Package) (*DepStats, error) {
 }
 
 func getDepStatsRec(pkg *Package, stats *DepStats, depth int) error {
-	return pkg.ForEachDep(func(dep *Dependency, dpkg *Package) error {
+	return pkg.ForEachDep(func(dep *Dependency, hash string, dpkg *Package) error {
 		stats.TotalCount++
 		stats.totalDepth += depth
 
-		ps, ok := stats.Packages[dep.Hash]
+		ps, ok := stats.Packages[hash]
 		if !ok {
 			stats.TotalUnique++
 			ps = new(PkgStats)
-			stats.Packages[dep.Hash] = ps
+			stats.Packages[hash] = ps
 		}
 
 		ps.totalDepth += depth
@@ -613,6 +675,28 @@ func LoadPackage(out interface{}, env, hash string) error {
 	return FindPackageInDir(out, p)
 }
 
+// PackageDir returns the directory the package with the given hash is
+// installed in, looking in the global install path first like LoadPackage
+func PackageDir(env, hash string) (string, error) {
+	for _, global := range []bool{true, false} {
+		ipath, err := InstallPath(env, "", global)
+		if err != nil {
+			return "", err
+		}
+
+		p := filepath.Join(ipath, "gx", "ipfs", hash)
+		if _, err := os.Stat(filepath.Join(p, PkgFileName)); err == nil {
+			return p, nil
+		}
+
+		if name, err := PackageNameInDir(p); err == nil {
+			return filepath.Join(p, name), nil
+		}
+	}
+
+	return "", fmt.Errorf("package %s is not installed", hash)
+}
+
 var ErrUnrecognizedName = fmt.Errorf("unrecognized package name")
 
 func resolveDepName(pkg *Package, out interface{}, dir, name string, checked map[string]struct{}) error {
@@ -691,30 +775,73 @@ func getSubtoolPath(env string) (string, error) {
 }
 
 func TryRunHook(hook, env string, req bool, args ...string) error {
+	return TryRunHookOpts(hook, env, req, nil, args...)
+}
+
+// TryRunHookEnv runs the given hook with extra environment variables set
+func TryRunHookEnv(hook, env string, req bool, extra []string, args ...string) error {
+	return TryRunHookOpts(hook, env, req, &HookOptions{Env: extra}, args...)
+}
+
+// TryRunHookOpts runs the given hook in the context described by opts, which
+// is sent to subtools speaking hook protocol 2
+func TryRunHookOpts(hook, env string, req bool, opts *HookOptions, args ...string) error {
+	if opts == nil {
+		opts = new(HookOptions)
+	}
+
+	replaced, err := runPkgHook(hook, opts, args)
+	if err != nil || replaced {
+		return err
+	}
+
+	resp, err := runHook(hook, env, req, opts, args)
+	if err != nil || resp == nil {
+		return err
+	}
+
+	return handleHookResponse(hook, env, opts, resp)
+}
+
+// runHook runs the given hook, returning the response of subtools speaking
+// hook protocol 2
+func runHook(hook, env string, req bool, opts *HookOptions, args []string) (*HookResponse, error) {
 	binname, err := getSubtoolPath(env)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if binname == "" {
 		if req {
-			return fmt.Errorf("no binary named gx-%s was found.", env)
+			return nil, fmt.Errorf("no binary named gx-%s was found.", env)
 		}
-		return nil
+		return nil, nil
+	}
+
+	if hc := subtoolCapabilities(binname); hc.Protocol >= 2 {
+		if !hc.handles(hook) {
+			VLog("  - gx-%s does not implement the %s hook", env, hook)
+			return nil, nil
+		}
+		return runHookProtocol(binname, hc, hook, opts, opts.SubtoolDir, args)
 	}
 
 	args = append([]string{"hook", hook}, args...)
 	cmd := exec.Command(binname, args...)
+	if len(opts.Env) > 0 {
+		cmd.Env = append(os.Environ(), opts.Env...)
+	}
+	cmd.Dir = opts.SubtoolDir
 	cmd.Stderr = os.Stderr
 	cmd.Stdout = os.Stdout
 	cmd.Stdin = os.Stdin
 
 	err = cmd.Run()
 	if err != nil {
-		return fmt.Errorf("%s hook failed: %s", hook, err)
+		return nil, fmt.Errorf("%s hook failed: %s", hook, err)
 	}
 
-	return nil
+	return nil, nil
 }
 
 const defaultLocalPath = "vendor"
@@ -737,11 +864,29 @@ func InstallPath(env, relpath string, global bool) (string, error) {
 		return defaultLocalPath, nil
 	}
 
-	args := []string{"hook", "install-path"}
+	var args []string
 	if global {
 		args = append(args, "--global")
 	}
-	cmd := exec.Command(binname, args...)
+
+	if hc := subtoolCapabilities(binname); hc.Protocol >= 2 {
+		if !hc.handles("install-path") {
+			return defaultLocalPath, nil
+		}
+
+		resp, err := runHookProtocol(binname, hc, "install-path", &HookOptions{Global: global}, relpath, args)
+		if err != nil {
+			return "", err
+		}
+		if resp.InstallPath == "" {
+			return "", fmt.Errorf("install-path hook of gx-%s returned no install path", env)
+		}
+
+		setInstallPathCache(env, global, resp.InstallPath)
+		return resp.InstallPath, nil
+	}
+
+	cmd := exec.Command(binname, append([]string{"hook", "install-path"}, args...)...)
 
 	cmd.Stderr = os.Stderr
 	cmd.Dir = relpath
@@ -771,8 +916,10 @@ func setInstallPathCache(env string, global bool, val string) {
 	installPathsCache[env] = val
 }
 
+// IsHash reports whether s is a CID, of any version or multibase
 func IsHash(s string) bool {
-	return strings.HasPrefix(s, "Qm") && len(s) == 46
+	_, err := cid.Decode(s)
+	return err == nil
 }
 
 // InstallDeps fetches all dependencies for the given package (in parallel)
@@ -816,11 +963,13 @@ func NewDependencyQueue(initialCapacity int) *DependencyQueue {
 }
 
 // AddPackageDependencies adds all of the dependencies of `pkg`
-// to the queue that had not been already added. Return the
+// (after applying its overrides) to the queue that had not been
+// already added. Return the
 // actual number of dependencies added to the queue.
 func (dq *DependencyQueue) AddPackageDependencies(pkg *Package) int {
 	addedDepCount := 0
 	for _, dep := range pkg.Dependencies {
+		dep = pkg.Overrides.Apply(dep)
 		if dq.added[dep.Hash] == false {
 			dq.queue = append(dq.queue, dep)
 			addedDepCount++
@@ -934,6 +1083,7 @@ func (pm *PM) fetchDependencies(pkg *Package, location string) error {
 		select {
 		case fetchedPkg := <-fetchedPackages:
 			VLog("fetched dep: %s", fetchedPkg.Name)
+			fetchedPkg.Overrides = pkg.Overrides
 			addedDepCount := depQueue.AddPackageDependencies(fetchedPkg)
 			pm.ProgMeter.AddTodos(addedDepCount)
 		case firstFetchErr = <-fetchErrs:
@@ -972,6 +1122,7 @@ func (pm *PM) fetchDependencies(pkg *Package, location string) error {
 // of the only sub-tool (`gx-go rewrite`) already does a parallel processing
 // of its own, so there's little to gain here.
 func (pm *PM) dependenciesPostInstall(pkg *Package, location string) error {
+	root := pkg
 	depQueue := NewDependencyQueue(len(pkg.Dependencies) * 2)
 
 	addedDepCount := depQueue.AddPackageDependencies(pkg)
@@ -993,10 +1144,11 @@ func (pm *PM) dependenciesPostInstall(pkg *Package, location string) error {
 		if err != nil {
 			return err
 		}
+		pkg.Overrides = root.Overrides
 
 		pm.ProgMeter.AddEntry(dep.Hash, dep.Name, "[install] <ELAPSED>"+dep.Hash)
 		pm.ProgMeter.Working(dep.Hash, "work")
-		if err := maybeRunPostInstall(pkg, pkgdir, pm.global); err != nil {
+		if err := pm.maybeRunPostInstall(pkg, pkgdir); err != nil {
 			pm.ProgMeter.Error(dep.Hash, err.Error())
 			return err
 		}
@@ -94,7 +94,7 @@ func (pm *PM) PublishPackage(dir string, pkg *PackageBase) (string, error) {
 	})
 
 	// we cant guarantee that the 'empty dir' object exists already
-	blank, err := pm.Shell().NewObject("unixfs-dir")
+	blank, err := pm.Store().NewDir()
 	if err != nil {
 		return "", err
 	}
@@ -106,12 +106,12 @@ func (pm *PM) PublishPackage(dir string, pkg *PackageBase) (string, error) {
 		return "", err
 	}
 
-	final, err := pm.Shell().PatchLink(pm.blankDir, pkg.Name, pkgdir, true)
+	final, err := pm.Store().PatchLink(pm.blankDir, pkg.Name, pkgdir, true)
 	if err != nil {
 		return "", err
 	}
 
-	return final, pm.Shell().Pin(final)
+	return final, pm.Store().Pin(final)
 }
 
 type filetree struct {
@@ -175,7 +175,7 @@ func (pm *PM) addFile(p string) (string, error) {
 	}
 	defer fi.Close()
 
-	return pm.Shell().AddNoPin(fi)
+	return pm.Store().AddFile(fi)
 }
 
 func (pm *PM) addPathElem(v *filetree, f, cwd string) (string, error) {
@@ -195,7 +195,7 @@ func (pm *PM) addPathElem(v *filetree, f, cwd string) (string, error) {
 				return "", err
 			}
 
-			return pm.Shell().AddLink(target)
+			return pm.Store().AddLink(target)
 		}
 
 		return pm.addFile(p)
@@ -211,7 +211,7 @@ func (pm *PM) addTree(nd *filetree, cwd string) (string, error) {
 		if err != nil {
 			return "", err
 		}
-		patched, err := pm.Shell().Patch(cur, "add-link", f, hash)
+		patched, err := pm.Store().PatchLink(cur, f, hash, false)
 		if err != nil {
 			return "", err
 		}
@@ -1,17 +1,74 @@
 package gxutil
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	hd "github.com/mitchellh/go-homedir"
+	"github.com/blang/semver"
 	. "github.com/whyrusleeping/stump"
 )
 
+// RepoIndexFile is the name of the file holding the index of a v2 repo.
+//
+// A v1 repo is a directory linking each package name to the hash of its
+// latest version. A v2 repo keeps those links and adds an index file
+// describing every package along with its full version history. Versions of
+// gx that predate the index list it as a package of its own; newer ones skip
+// dotfiles when reading a repo without an index.
+const RepoIndexFile = ".gxrepo.json"
+
+const RepoIndexVersion = 2
+
+// RepoIndex is the index of a v2 repo
+type RepoIndex struct {
+	Version  int                     `json:"version"`
+	Packages map[string]*RepoPackage `json:"packages"`
+}
+
+// RepoPackage describes a package in a repo
+type RepoPackage struct {
+	// Hash is the latest published version of the package
+	Hash        string   `json:"hash"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+
+	// Versions lists every published version, oldest first. It is empty
+	// for packages from v1 repos.
+	Versions []*RepoVersion `json:"versions,omitempty"`
+}
+
+// RepoVersion is a published version of a package in a repo
+type RepoVersion struct {
+	Version   string `json:"version"`
+	Hash      string `json:"hash"`
+	Published string `json:"published,omitempty"`
+}
+
+// FetchRepo returns the latest hash of every package in the repo at rpath
 func (pm *PM) FetchRepo(rpath string, usecache bool) (map[string]string, error) {
+	idx, err := pm.FetchRepoIndex(rpath, usecache)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]string)
+	for name, p := range idx.Packages {
+		out[name] = p.Hash
+	}
+
+	return out, nil
+}
+
+// FetchRepoIndex reads the repo at rpath, in either the v1 or v2 layout
+func (pm *PM) FetchRepoIndex(rpath string, usecache bool) (*RepoIndex, error) {
 	if strings.HasPrefix(rpath, "/ipns/") {
 		p, err := pm.ResolveRepoName(rpath, usecache)
 		if err != nil {
@@ -20,119 +77,271 @@ func (pm *PM) FetchRepo(rpath string, usecache bool) (map[string]string, error)
 
 		rpath = p
 	}
-	links, err := pm.Shell().List(rpath)
+	links, err := pm.Store().List(rpath)
 	if err != nil {
 		return nil, err
 	}
 
-	out := make(map[string]string)
+	idx := &RepoIndex{
+		Version:  1,
+		Packages: make(map[string]*RepoPackage),
+	}
 	for _, l := range links {
-		out[l.Name] = l.Hash
+		if l.Name == RepoIndexFile {
+			return pm.readRepoIndex(l.Hash)
+		}
 	}
 
-	return out, nil
+	for _, l := range links {
+		// dotfiles hold repo metadata, like the index, not packages
+		if strings.HasPrefix(l.Name, ".") {
+			continue
+		}
+
+		idx.Packages[l.Name] = &RepoPackage{Hash: l.Hash}
+	}
+
+	return idx, nil
 }
 
-var ErrNotFound = errors.New("cache miss")
+func (pm *PM) readRepoIndex(hash string) (*RepoIndex, error) {
+	data, err := pm.readStoreFile(hash)
+	if err != nil {
+		return nil, fmt.Errorf("fetching repo index: %s", err)
+	}
 
-// TODO: once on ipfs 0.4.0, use the files api
-func (pm *PM) ResolveRepoName(name string, usecache bool) (string, error) {
-	if usecache {
-		cache, ok, err := CheckCacheFile(name)
+	var idx RepoIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("parsing repo index: %s", err)
+	}
+
+	if idx.Version != RepoIndexVersion {
+		return nil, fmt.Errorf("unsupported repo index version %d", idx.Version)
+	}
+
+	if idx.Packages == nil {
+		idx.Packages = make(map[string]*RepoPackage)
+	}
+
+	return &idx, nil
+}
+
+// readStoreFile returns the contents of the file object at path
+func (pm *PM) readStoreFile(path string) ([]byte, error) {
+	dir, err := ioutil.TempDir("", "gx-file")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "file")
+	if err := pm.Store().Get(path, out); err != nil {
+		return nil, err
+	}
+
+	return ioutil.ReadFile(out)
+}
+
+// AddToRepo adds pkg, published as hash, to the repo directory root and
+// records the version in the repo index, upgrading v1 repos to v2 as
+// needed. It returns the hash of the updated repo.
+func (pm *PM) AddToRepo(root string, pkg *PackageBase, hash string) (string, error) {
+	idx, err := pm.FetchRepoIndex(root, false)
+	if err != nil {
+		return "", fmt.Errorf("fetching repo: %s", err)
+	}
+
+	rpkg, ok := idx.Packages[pkg.Name]
+	if !ok {
+		rpkg = new(RepoPackage)
+		idx.Packages[pkg.Name] = rpkg
+	}
+
+	if rpkg.Hash != "" && len(rpkg.Versions) == 0 {
+		// carried over from a v1 repo, start the history with the version
+		// that was there before
+		prev, err := pm.repoVersionOf(rpkg.Hash)
 		if err != nil {
 			return "", err
 		}
+		rpkg.Versions = append(rpkg.Versions, prev)
+	}
 
-		if ok {
-			return cache, nil
+	var exists bool
+	for _, v := range rpkg.Versions {
+		switch {
+		case v.Hash == hash:
+			exists = true
+		case v.Version == pkg.Version:
+			return "", fmt.Errorf("version %s of %s is already in the repo as %s", v.Version, pkg.Name, v.Hash)
 		}
 	}
 
-	out, err := pm.Shell().ResolvePath(name)
-	if err != nil {
-		Error("error from resolve path", name)
-		return "", err
+	if !exists {
+		rpkg.Versions = append(rpkg.Versions, &RepoVersion{
+			Version:   pkg.Version,
+			Hash:      hash,
+			Published: time.Now().UTC().Format(time.RFC3339),
+		})
+	} else {
+		VLog("  - %s %s is already in the repo", pkg.Name, hash)
 	}
 
-	err = pm.cacheSet(name, out)
+	rpkg.Hash = latestRepoVersion(rpkg.Versions)
+	rpkg.Description = pkg.Description
+	rpkg.Keywords = pkg.Keywords
+	idx.Version = RepoIndexVersion
+
+	data, err := json.MarshalIndent(idx, "", "  ")
 	if err != nil {
 		return "", err
 	}
 
-	return out, nil
-}
-
-func CheckCacheFile(name string) (string, bool, error) {
-	home, err := hd.Dir()
+	idxhash, err := pm.Store().AddFile(bytes.NewReader(data))
 	if err != nil {
-		return "", false, err
+		return "", fmt.Errorf("adding repo index: %s", err)
 	}
-	p := filepath.Join(home, ".gxcache")
 
-	fi, err := os.Open(p)
+	root = strings.TrimPrefix(root, "/ipfs/")
+	root, err = pm.Store().PatchLink(root, RepoIndexFile, idxhash, false)
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return "", false, err
-		}
+		return "", fmt.Errorf("patching repo index: %s", err)
 	}
-	defer fi.Close()
 
-	cache := make(map[string]string)
-	err = json.NewDecoder(fi).Decode(&cache)
+	root, err = pm.Store().PatchLink(root, pkg.Name, rpkg.Hash, false)
 	if err != nil {
-		return "", false, err
+		return "", fmt.Errorf("patching %s into repo: %s", pkg.Name, err)
 	}
 
-	v, ok := cache[name]
-	if ok {
-		return v, true, nil
-	}
-	return "", false, nil
+	return root, pm.Store().Pin(root)
 }
 
-// TODO: think about moving gx global files into a .config/local type thing
-func (pm *PM) cacheSet(name, resolved string) error {
-	home, err := hd.Dir()
+func (pm *PM) repoVersionOf(hash string) (*RepoVersion, error) {
+	dir, err := ioutil.TempDir("", "gx-repo")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	p := filepath.Join(home, ".gxcache")
+	defer os.RemoveAll(dir)
 
-	_, err = os.Stat(p)
+	pkg, err := pm.GetPackageTo(hash, filepath.Join(dir, hash))
 	if err != nil {
-		if !os.IsNotExist(err) {
-			return err
-		}
+		return nil, fmt.Errorf("fetching %s: %s", hash, err)
 	}
 
-	cache := make(map[string]string)
-	if err == nil { // if the file already exists
-		fi, err := os.Open(p)
+	return &RepoVersion{Version: pkg.Version, Hash: hash}, nil
+}
+
+// latestRepoVersion returns the hash of the highest version, or of the most
+// recently published one if no version parses
+func latestRepoVersion(vers []*RepoVersion) string {
+	latest := vers[len(vers)-1]
+	var best *semver.Version
+	for _, v := range vers {
+		sv, err := semver.Parse(v.Version)
 		if err != nil {
-			return err
+			continue
 		}
 
-		err = json.NewDecoder(fi).Decode(&cache)
-		if err != nil {
-			return err
+		if best == nil || sv.GT(*best) {
+			best = &sv
+			latest = v
 		}
+	}
+	return latest.Hash
+}
+
+// PublishRepo points the ipns name of rpath at the repo hash, and updates the
+// cached resolution of rpath to match.
+func (pm *PM) PublishRepo(rpath, hash string) error {
+	if !strings.HasPrefix(rpath, "/ipns/") {
+		return fmt.Errorf("repo path %s is not an ipns name", rpath)
+	}
+
+	name := strings.SplitN(strings.TrimPrefix(rpath, "/ipns/"), "/", 2)
+	if len(name) > 1 {
+		return fmt.Errorf("cannot publish to subpath of ipns name: %s", rpath)
+	}
 
-		fi.Close()
+	if err := pm.Store().Publish(name[0], hash); err != nil {
+		return fmt.Errorf("publishing %s: %s", rpath, err)
 	}
 
-	cache[name] = resolved
+	return pm.cacheSet(rpath, hash)
+}
+
+var ErrNotFound = errors.New("cache miss")
+
+// ResolveRepoName resolves the repo path name. If usecache is set, a cached
+// resolution is used as long as it has not expired, and as a fallback when
+// the name cannot be resolved.
+func (pm *PM) ResolveRepoName(name string, usecache bool) (string, error) {
+	var cached *CacheEntry
+	if usecache {
+		cache, err := LoadCache()
+		if err != nil {
+			return "", err
+		}
+
+		cached = cache[name]
+		if cached != nil && !cached.Expired(pm.CacheTTL()) {
+			return cached.Value, nil
+		}
+	}
 
-	fi, err := os.Create(p)
+	out, err := pm.Store().Resolve(name)
 	if err != nil {
-		return err
+		if cached != nil {
+			Error("could not resolve %s, using expired cache entry: %s", name, err)
+			return cached.Value, nil
+		}
+
+		Error("error from resolve path", name)
+		return "", err
 	}
 
-	err = json.NewEncoder(fi).Encode(cache)
+	err = pm.cacheSet(name, out)
 	if err != nil {
-		return err
+		return "", err
+	}
+
+	return out, nil
+}
+
+// RepoMatch is a repo listing a queried package
+type RepoMatch struct {
+	*RepoRef
+	Hash string `json:"hash"`
+}
+
+// QueryReposOrdered returns the repos listing the named package, in the
+// order they are used to resolve names. The first match is the one a name
+// resolves to. Like PackageVersions, repos that can't be read are skipped
+// with a warning, unless none of them can.
+func (pm *PM) QueryReposOrdered(query string) ([]*RepoMatch, error) {
+	return pm.queryReposOrdered(query, pm.cfg.OrderedRepos())
+}
+
+func (pm *PM) queryReposOrdered(query string, repos []*RepoRef) ([]*RepoMatch, error) {
+	var out []*RepoMatch
+	var failed []string
+	for _, r := range repos {
+		repo, err := pm.FetchRepo(r.Path, true)
+		if err != nil {
+			Log("warning: skipping repo %s: %s", r.Name, err)
+			failed = append(failed, fmt.Sprintf("%s: %s", r.Name, err))
+			continue
+		}
+
+		if val, ok := repo[query]; ok {
+			out = append(out, &RepoMatch{RepoRef: r, Hash: val})
+		}
 	}
 
-	return fi.Close()
+	if len(repos) > 0 && len(failed) == len(repos) {
+		return nil, fmt.Errorf("no repo could be read: %s", strings.Join(failed, "; "))
+	}
+
+	return out, nil
 }
 
 func (pm *PM) QueryRepos(query string) (map[string]string, error) {
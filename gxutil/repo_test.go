@@ -0,0 +1,133 @@
+package gxutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestFetchRepoIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := &PM{store: s, cfg: new(Config)}
+
+	// a v1 repo, with metadata next to its packages
+	repo := filepath.Join(dir, "repo")
+	if err := os.MkdirAll(filepath.Join(repo, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a/" + PkgFileName, ".metadata"} {
+		if err := ioutil.WriteFile(filepath.Join(repo, name), []byte("{}"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	root, _, err := buildPath(repo, defaultFormat, s.put)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := hashPath(filepath.Join(repo, "a"), defaultFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err := pm.FetchRepoIndex("/ipfs/"+root, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version != 1 || len(idx.Packages) != 1 || idx.Packages["a"] == nil || idx.Packages["a"].Hash != a {
+		t.Fatalf("unexpected v1 index: %+v", idx.Packages)
+	}
+
+	// adding a package turns it into a v2 repo, keeping the v1 packages
+	nroot, err := pm.AddToRepo(root, &PackageBase{Name: "b", Version: "1.0.0"}, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err = pm.FetchRepoIndex("/ipfs/"+nroot, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if idx.Version != RepoIndexVersion || len(idx.Packages) != 2 || idx.Packages["a"] == nil || idx.Packages["b"] == nil {
+		t.Fatalf("unexpected v2 index: %+v", idx.Packages)
+	}
+}
+
+func TestQueryReposOrdered(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-repo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := &PM{store: s, cfg: new(Config)}
+
+	var roots []string
+	for i, idx := range []string{
+		`{"version": 2, "packages": {"a": {"hash": "QmA1"}}}`,
+		`{"version": 2, "packages": {"a": {"hash": "QmA2"}, "b": {"hash": "QmB"}}}`,
+	} {
+		repo := filepath.Join(dir, "repo"+strconv.Itoa(i))
+		if err := os.Mkdir(repo, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(repo, RepoIndexFile), []byte(idx), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		root, _, err := buildPath(repo, defaultFormat, s.put)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots = append(roots, root)
+	}
+
+	// a repo that was never added to the store
+	if err := os.Mkdir(filepath.Join(dir, "missing"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := hashPath(filepath.Join(dir, "missing"), defaultFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := &RepoRef{Name: "first", Path: "/ipfs/" + roots[0]}
+	broken := &RepoRef{Name: "broken", Path: "/ipfs/" + missing}
+	second := &RepoRef{Name: "second", Path: "/ipfs/" + roots[1]}
+	repos := []*RepoRef{first, broken, second}
+
+	out, err := pm.queryReposOrdered("a", repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 2 || out[0].Name != "first" || out[0].Hash != "QmA1" || out[1].Hash != "QmA2" {
+		t.Fatalf("unexpected matches: %v", out)
+	}
+
+	out, err = pm.queryReposOrdered("b", repos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out) != 1 || out[0].Name != "second" {
+		t.Fatalf("unexpected matches: %v", out)
+	}
+
+	if _, err := pm.queryReposOrdered("a", []*RepoRef{broken}); err == nil {
+		t.Fatal("expected an error when no repo can be read")
+	}
+}
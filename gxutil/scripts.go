@@ -0,0 +1,101 @@
+package gxutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	. "github.com/whyrusleeping/stump"
+)
+
+// PkgHook is a shell command run for a hook, set in the gxHooks section of
+// package.json as a plain string, or as {"run": "...", "replace": true}
+type PkgHook struct {
+	Run string `json:"run"`
+
+	// Replace skips the hook of the language subtool
+	Replace bool `json:"replace,omitempty"`
+}
+
+func (h *PkgHook) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '"' {
+		return json.Unmarshal(data, &h.Run)
+	}
+
+	type plain PkgHook
+	return json.Unmarshal(data, (*plain)(h))
+}
+
+func (h *PkgHook) MarshalJSON() ([]byte, error) {
+	if !h.Replace {
+		return json.Marshal(h.Run)
+	}
+
+	type plain PkgHook
+	return json.Marshal((*plain)(h))
+}
+
+// ScriptEnv returns the environment package scripts are run with, describing
+// the package and the hash it is published as, if known
+func ScriptEnv(pkg *PackageBase, hash string) []string {
+	return append(
+		os.Environ(),
+		"VERSION="+pkg.Version, // deprecated.
+		"GX_VERSION="+pkg.Version,
+		"GX_NAME="+pkg.Name,
+		"GX_LANGUAGE="+pkg.Language,
+		"GX_LICENSE="+pkg.License,
+		"GX_AUTHOR="+pkg.Author,
+		"GX_HASH="+hash,
+	)
+}
+
+// ScriptCommand returns the command running script in a shell, in dir. args
+// are passed as the positional parameters of the script.
+func ScriptCommand(script, dir string, env []string, args ...string) *exec.Cmd {
+	cmd := exec.Command("sh", append([]string{"-c", script, "sh"}, args...)...)
+	cmd.Env = env
+	cmd.Dir = dir
+	cmd.Stderr = os.Stderr
+	cmd.Stdout = os.Stdout
+	cmd.Stdin = os.Stdin
+	return cmd
+}
+
+// runPkgHook runs the gxHooks script of the package in opts for hook, if it
+// has one. It reports whether the script replaces the hook of the subtool.
+func runPkgHook(hook string, opts *HookOptions, args []string) (bool, error) {
+	if opts.Pkg == nil {
+		return false, nil
+	}
+
+	h, ok := opts.Pkg.Hooks[hook]
+	if !ok || h == nil || h.Run == "" {
+		return false, nil
+	}
+
+	if opts.Dependency && !opts.AllowDepHooks {
+		Log("warning: not running %s script of dependency %s, set allow_dep_hooks to allow it", hook, opts.Pkg.Name)
+		return false, nil
+	}
+
+	env := ScriptEnv(&opts.Pkg.PackageBase, "")
+	env = append(env, "GX_HOOK="+hook)
+	if opts.InstallPath != "" {
+		env = append(env, "GX_INSTALL_PATH="+opts.InstallPath)
+	}
+	if opts.Global {
+		env = append(env, "GX_GLOBAL=1")
+	}
+	env = append(env, opts.Env...)
+
+	VLog("  - running %s script of %s: %s", hook, opts.Pkg.Name, h.Run)
+	cmd := ScriptCommand(h.Run, opts.Dir, env, args...)
+
+	if err := cmd.Run(); err != nil {
+		return false, fmt.Errorf("%s script of %s failed: %s", hook, opts.Pkg.Name, err)
+	}
+
+	return h.Replace, nil
+}
@@ -0,0 +1,131 @@
+package gxutil
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPkgHookJSON(t *testing.T) {
+	data := `{"a": "make", "b": {"run": "make deps", "replace": true}}`
+
+	var hooks map[string]*PkgHook
+	if err := json.Unmarshal([]byte(data), &hooks); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := map[string]*PkgHook{
+		"a": {Run: "make"},
+		"b": {Run: "make deps", Replace: true},
+	}
+	if !reflect.DeepEqual(hooks, exp) {
+		t.Fatalf("unexpected hooks: %v", hooks)
+	}
+
+	// plain scripts are written back as strings
+	out, err := json.Marshal(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != `{"a":"make","b":{"run":"make deps","replace":true}}` {
+		t.Fatalf("unexpected json: %s", out)
+	}
+}
+
+func TestRunPkgHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkg := &Package{
+		PackageBase: PackageBase{
+			Name: "pkg",
+			Hooks: map[string]*PkgHook{
+				"post-publish": {Run: `echo "$GX_NAME $GX_HOOK $1" > out`},
+				"post-update":  {Run: "true", Replace: true},
+			},
+		},
+	}
+	opts := &HookOptions{Pkg: pkg, Dir: dir}
+
+	replace, err := runPkgHook("post-publish", opts, []string{"QmHash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replace {
+		t.Fatal("post-publish should not replace the subtool hook")
+	}
+
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "pkg post-publish QmHash\n" {
+		t.Fatalf("unexpected script output: %q", out)
+	}
+
+	if replace, err := runPkgHook("post-update", opts, nil); err != nil || !replace {
+		t.Fatalf("post-update should replace the subtool hook, got %t, %v", replace, err)
+	}
+
+	if replace, err := runPkgHook("pre-test", opts, nil); err != nil || replace {
+		t.Fatalf("hooks without a script should do nothing, got %t, %v", replace, err)
+	}
+
+	pkg.Hooks["post-publish"].Run = "exit 1"
+	if _, err := runPkgHook("post-publish", opts, nil); err == nil {
+		t.Fatal("expected an error from a failing script")
+	}
+}
+
+func TestRunPkgHookDependency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-scripts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	pkg := &Package{
+		PackageBase: PackageBase{
+			Name: "dep",
+			Hooks: map[string]*PkgHook{
+				"post-install": {Run: "touch ran", Replace: true},
+			},
+		},
+	}
+
+	cases := []struct {
+		dep, allow bool
+		ran        bool
+	}{
+		{dep: false, ran: true},
+		{dep: true, ran: false},
+		{dep: true, allow: true, ran: true},
+	}
+
+	for _, c := range cases {
+		marker := filepath.Join(dir, "ran")
+		os.Remove(marker)
+
+		opts := &HookOptions{Pkg: pkg, Dir: dir, Dependency: c.dep, AllowDepHooks: c.allow}
+		replace, err := runPkgHook("post-install", opts, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = os.Stat(marker)
+		if ran := err == nil; ran != c.ran {
+			t.Errorf("dependency %t, allowed %t: expected script to run: %t, ran: %t", c.dep, c.allow, c.ran, ran)
+		}
+
+		// a skipped script can't replace the hook of the subtool
+		if replace != c.ran {
+			t.Errorf("dependency %t, allowed %t: expected replace %t, got %t", c.dep, c.allow, c.ran, replace)
+		}
+	}
+}
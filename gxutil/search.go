@@ -0,0 +1,327 @@
+package gxutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	. "github.com/whyrusleeping/stump"
+)
+
+// SearchIndexFile is the name of the search index in the cache directory
+const SearchIndexFile = "search.json"
+
+// SearchIndex holds the metadata of every package in the configured repos,
+// so they can be searched without going to the network
+type SearchIndex struct {
+	// repo name -> state of the repo the entries were built from
+	Repos   map[string]*SearchRepo `json:"repos"`
+	Entries []*SearchEntry         `json:"entries"`
+}
+
+// SearchRepo records which version of a repo was indexed
+type SearchRepo struct {
+	Path string `json:"path"`
+	Hash string `json:"hash"`
+}
+
+// SearchEntry is a package in the search index
+type SearchEntry struct {
+	Repo        string   `json:"repo"`
+	Name        string   `json:"name"`
+	Version     string   `json:"version"`
+	Hash        string   `json:"hash"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Author      string   `json:"author,omitempty"`
+	Language    string   `json:"language,omitempty"`
+}
+
+// SearchResult is a search index entry matching a query
+type SearchResult struct {
+	*SearchEntry
+	Score int `json:"score"`
+}
+
+// LoadSearchIndex reads the search index, returning an empty one if it has
+// not been built yet
+func LoadSearchIndex() (*SearchIndex, error) {
+	idx := &SearchIndex{Repos: make(map[string]*SearchRepo)}
+
+	p, err := cachePath(SearchIndexFile)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, idx); err != nil {
+		return nil, fmt.Errorf("parsing search index %s: %s", p, err)
+	}
+
+	if idx.Repos == nil {
+		idx.Repos = make(map[string]*SearchRepo)
+	}
+	return idx, nil
+}
+
+func (idx *SearchIndex) save() error {
+	p, err := cachePath(SearchIndexFile)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(p, data)
+}
+
+// UpdateSearchIndex brings the search index in line with the configured
+// repos. Repos that are not indexed yet are always fetched. If refresh is
+// set, indexed repos are resolved again and reindexed if they changed,
+// otherwise the index is used as is and no network access is needed.
+func (pm *PM) UpdateSearchIndex(refresh bool) (*SearchIndex, error) {
+	idx, err := LoadSearchIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	repos := pm.cfg.GetRepos()
+
+	var changed bool
+	for rname := range idx.Repos {
+		if _, ok := repos[rname]; !ok {
+			idx.dropRepo(rname)
+			changed = true
+		}
+	}
+
+	for rname, rpath := range repos {
+		old, ok := idx.Repos[rname]
+		if ok && old.Path == rpath && !refresh {
+			continue
+		}
+
+		hash, err := pm.resolveRepoHash(rpath, !refresh)
+		if err != nil {
+			return nil, fmt.Errorf("resolving repo %s: %s", rname, err)
+		}
+
+		if ok && old.Path == rpath && old.Hash == hash {
+			VLog("  - repo %s is up to date", rname)
+			continue
+		}
+
+		Log("indexing repo %s...", rname)
+		entries, err := pm.indexRepo(rname, hash)
+		if err != nil {
+			return nil, err
+		}
+
+		idx.dropRepo(rname)
+		idx.Repos[rname] = &SearchRepo{Path: rpath, Hash: hash}
+		idx.Entries = append(idx.Entries, entries...)
+		changed = true
+	}
+
+	if changed {
+		if err := idx.save(); err != nil {
+			return nil, fmt.Errorf("writing search index: %s", err)
+		}
+	}
+
+	return idx, nil
+}
+
+func (pm *PM) resolveRepoHash(rpath string, usecache bool) (string, error) {
+	if strings.HasPrefix(rpath, "/ipns/") {
+		return pm.ResolveRepoName(rpath, usecache)
+	}
+	return pm.Store().Resolve(rpath)
+}
+
+func (idx *SearchIndex) dropRepo(rname string) {
+	delete(idx.Repos, rname)
+
+	var keep []*SearchEntry
+	for _, e := range idx.Entries {
+		if e.Repo != rname {
+			keep = append(keep, e)
+		}
+	}
+	idx.Entries = keep
+}
+
+func (pm *PM) indexRepo(rname, hash string) ([]*SearchEntry, error) {
+	ridx, err := pm.FetchRepoIndex("/ipfs/"+hash, false)
+	if err != nil {
+		return nil, fmt.Errorf("fetching repo %s: %s", rname, err)
+	}
+
+	dir, err := ioutil.TempDir("", "gx-search")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	var out []*SearchEntry
+	for name, rpkg := range ridx.Packages {
+		pkg, err := pm.fetchPackageFile(rpkg.Hash, filepath.Join(dir, rpkg.Hash))
+		if err != nil {
+			Error("skipping %s in repo %s: %s", name, rname, err)
+			continue
+		}
+
+		e := &SearchEntry{
+			Repo:        rname,
+			Name:        name,
+			Version:     pkg.Version,
+			Hash:        rpkg.Hash,
+			Description: pkg.Description,
+			Keywords:    pkg.Keywords,
+			Author:      pkg.Author,
+			Language:    pkg.Language,
+		}
+
+		// the repo index may know more than the package itself
+		if rpkg.Description != "" {
+			e.Description = rpkg.Description
+		}
+		if len(rpkg.Keywords) > 0 {
+			e.Keywords = rpkg.Keywords
+		}
+
+		out = append(out, e)
+	}
+
+	return out, nil
+}
+
+// fetchPackageFile fetches only the package file of the package at hash
+func (pm *PM) fetchPackageFile(hash, dir string) (*Package, error) {
+	links, err := pm.Store().List(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	for _, l := range links {
+		out := filepath.Join(dir, PkgFileName)
+		if err := pm.Store().Get(hash+"/"+l.Name+"/"+PkgFileName, out); err != nil {
+			continue
+		}
+
+		var pkg Package
+		if err := LoadPackageFile(&pkg, out); err != nil {
+			return nil, err
+		}
+		return &pkg, nil
+	}
+
+	return nil, fmt.Errorf("no %s found", PkgFileName)
+}
+
+// Search returns the entries matching all of the given terms, best matches
+// first. Terms are matched case insensitively against the name, keywords,
+// description, author and language of each package, and loosely against
+// the name.
+func (idx *SearchIndex) Search(terms []string) []*SearchResult {
+	var out []*SearchResult
+	for _, e := range idx.Entries {
+		total := 0
+		for _, t := range terms {
+			s := e.score(strings.ToLower(t))
+			if s == 0 {
+				total = 0
+				break
+			}
+			total += s
+		}
+
+		if total > 0 {
+			out = append(out, &SearchResult{SearchEntry: e, Score: total})
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		switch {
+		case out[i].Score != out[j].Score:
+			return out[i].Score > out[j].Score
+		case out[i].Name != out[j].Name:
+			return out[i].Name < out[j].Name
+		default:
+			return out[i].Repo < out[j].Repo
+		}
+	})
+	return out
+}
+
+func (e *SearchEntry) score(term string) int {
+	name := strings.ToLower(e.Name)
+
+	var s int
+	switch {
+	case name == term:
+		s += 20
+	case strings.HasPrefix(name, term):
+		s += 12
+	case strings.Contains(name, term):
+		s += 8
+	case fuzzyMatch(name, term):
+		s += 2
+	}
+
+	for _, k := range e.Keywords {
+		k = strings.ToLower(k)
+		if k == term {
+			s += 10
+			break
+		}
+		if strings.Contains(k, term) {
+			s += 5
+			break
+		}
+	}
+
+	if strings.Contains(strings.ToLower(e.Description), term) {
+		s += 4
+	}
+
+	if strings.Contains(strings.ToLower(e.Author), term) {
+		s += 2
+	}
+
+	if strings.ToLower(e.Language) == term {
+		s += 2
+	}
+
+	return s
+}
+
+// fuzzyMatch reports whether all characters of term appear in s, in order
+func fuzzyMatch(s, term string) bool {
+	for _, c := range term {
+		i := strings.IndexRune(s, c)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(c)):]
+	}
+	return true
+}
@@ -0,0 +1,352 @@
+package gxutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/blang/semver"
+	. "github.com/whyrusleeping/stump"
+)
+
+// ParseVersionRange parses a semver range. On top of the comparisons
+// understood by semver.ParseRange (">=1.2.0 <2.0.0 || 3.0.0"), it accepts
+// the npm style shorthands "^1.2.3", "~1.2.3", "1.x", "1.2.x" and "*",
+// carets and tildes of partial versions ("^1.2", "~1"),
+// comparisons with partial versions (">1.2") and operators separated from
+// their version by spaces (">= 1.2.0").
+func ParseVersionRange(s string) (semver.Range, error) {
+	var alts []string
+	for _, alt := range strings.Split(s, "||") {
+		var parts []string
+		fields := strings.Fields(alt)
+		for i := 0; i < len(fields); i++ {
+			tok := fields[i]
+
+			// an operator separated from its version, as in ">= 1.2.0"
+			if strings.Trim(tok, "<>=!^~") == "" && i+1 < len(fields) {
+				i++
+				tok += fields[i]
+			}
+
+			exp, err := expandRangeToken(tok)
+			if err != nil {
+				return nil, fmt.Errorf("invalid range %q: %s", s, err)
+			}
+			parts = append(parts, exp)
+		}
+		if len(parts) == 0 {
+			parts = append(parts, ">=0.0.0")
+		}
+		alts = append(alts, strings.Join(parts, " "))
+	}
+
+	r, err := semver.ParseRange(strings.Join(alts, " || "))
+	if err != nil {
+		return nil, fmt.Errorf("invalid range %q: %s", s, err)
+	}
+	return r, nil
+}
+
+func expandRangeToken(tok string) (string, error) {
+	switch {
+	case strings.HasPrefix(tok, "^"):
+		if nums, ok := partialVersion(tok[1:]); ok && len(nums) < 3 {
+			return expandPartial('^', nums), nil
+		}
+
+		v, err := semver.Parse(tok[1:])
+		if err != nil {
+			return "", err
+		}
+
+		upper := semver.Version{Major: v.Major + 1}
+		switch {
+		case v.Major == 0 && v.Minor == 0:
+			upper = semver.Version{Patch: v.Patch + 1}
+		case v.Major == 0:
+			upper = semver.Version{Minor: v.Minor + 1}
+		}
+		return fmt.Sprintf(">=%s <%s", v, upper), nil
+	case strings.HasPrefix(tok, "~"):
+		if nums, ok := partialVersion(tok[1:]); ok && len(nums) < 3 {
+			return expandPartial('~', nums), nil
+		}
+
+		v, err := semver.Parse(tok[1:])
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf(">=%s <%d.%d.0", v, v.Major, v.Minor+1), nil
+	case strings.IndexAny(tok[:1], "<>=!") == 0:
+		return expandComparison(tok), nil
+	}
+
+	nums, ok := partialVersion(tok)
+	if !ok {
+		// let semver.ParseRange deal with it
+		return tok, nil
+	}
+
+	switch len(nums) {
+	case 0:
+		return ">=0.0.0", nil
+	case 1, 2:
+		return fmt.Sprintf(">=%s <%s", lowerBound(nums), upperBound(nums)), nil
+	default:
+		return tok, nil
+	}
+}
+
+// expandPartial expands a caret or tilde range of a partial version, the
+// way npm does: "^1.2" means ">=1.2.0 <2.0.0", "^0.2" means ">=0.2.0 <0.3.0"
+// and "~1" means ">=1.0.0 <2.0.0".
+func expandPartial(op byte, nums []uint64) string {
+	if len(nums) == 0 {
+		return ">=0.0.0"
+	}
+
+	upper := upperBound(nums)
+	if op == '^' && len(nums) == 2 && nums[0] > 0 {
+		upper = upperBound(nums[:1])
+	}
+	return fmt.Sprintf(">=%s <%s", lowerBound(nums), upper)
+}
+
+// expandComparison expands a comparison with a partial version, the way npm
+// does: ">1.2" means ">=1.3.0" and "<=1" means "<2.0.0".
+func expandComparison(tok string) string {
+	v := strings.TrimLeft(tok, "<>=!")
+	op := tok[:len(tok)-len(v)]
+
+	nums, ok := partialVersion(v)
+	if !ok || len(nums) == 0 || len(nums) > 2 {
+		return tok
+	}
+
+	switch op {
+	case ">=":
+		return ">=" + lowerBound(nums)
+	case ">":
+		return ">=" + upperBound(nums)
+	case "<":
+		return "<" + lowerBound(nums)
+	case "<=":
+		return "<" + upperBound(nums)
+	case "=", "==":
+		return fmt.Sprintf(">=%s <%s", lowerBound(nums), upperBound(nums))
+	default:
+		return tok
+	}
+}
+
+// partialVersion parses the numbers of a version that may be cut short or
+// end in a wildcard, as in "1", "1.2" or "1.x"
+func partialVersion(s string) ([]uint64, bool) {
+	var nums []uint64
+	for _, p := range strings.Split(s, ".") {
+		if p == "x" || p == "X" || p == "*" {
+			break
+		}
+
+		n, err := strconv.ParseUint(p, 10, 64)
+		if err != nil {
+			return nil, false
+		}
+		nums = append(nums, n)
+	}
+	return nums, true
+}
+
+// lowerBound returns the lowest version matching a partial version
+func lowerBound(nums []uint64) string {
+	if len(nums) == 1 {
+		return fmt.Sprintf("%d.0.0", nums[0])
+	}
+	return fmt.Sprintf("%d.%d.0", nums[0], nums[1])
+}
+
+// upperBound returns the lowest version above a partial version
+func upperBound(nums []uint64) string {
+	if len(nums) == 1 {
+		return fmt.Sprintf("%d.0.0", nums[0]+1)
+	}
+	return fmt.Sprintf("%d.%d.0", nums[0], nums[1]+1)
+}
+
+// PkgVersion is a published version of a package
+type PkgVersion struct {
+	Repo    string `json:"repo"`
+	Version string `json:"version"`
+	Hash    string `json:"hash"`
+}
+
+// PackageVersions returns the versions of the named package published in
+// the configured repos, highest first. Versions found in several repos are
+// attributed to the one with the highest priority. Repos that can't be read
+// are skipped with a warning, unless none of them can.
+func (pm *PM) PackageVersions(name string) ([]*PkgVersion, error) {
+	return pm.packageVersions(name, pm.cfg.OrderedRepos())
+}
+
+func (pm *PM) packageVersions(name string, repos []*RepoRef) ([]*PkgVersion, error) {
+	tmpdir, err := ioutil.TempDir("", "gx-versions")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var out []*PkgVersion
+	var failed []string
+	seen := make(map[string]bool)
+	for _, r := range repos {
+		rname := r.Name
+		idx, err := pm.FetchRepoIndex(r.Path, true)
+		if err != nil {
+			Log("warning: skipping repo %s: %s", rname, err)
+			failed = append(failed, fmt.Sprintf("%s: %s", rname, err))
+			continue
+		}
+
+		rpkg, ok := idx.Packages[name]
+		if !ok {
+			continue
+		}
+
+		for _, v := range rpkg.Versions {
+			if seen[v.Hash] {
+				continue
+			}
+			seen[v.Hash] = true
+
+			out = append(out, &PkgVersion{
+				Repo:    rname,
+				Version: v.Version,
+				Hash:    v.Hash,
+			})
+		}
+
+		if seen[rpkg.Hash] {
+			continue
+		}
+		seen[rpkg.Hash] = true
+
+		// v1 repos only know the latest hash, the version has to come from
+		// the package itself
+		pkg, err := pm.GetPackageTo(rpkg.Hash, filepath.Join(tmpdir, rpkg.Hash))
+		if err != nil {
+			Log("warning: skipping %s from repo %s: %s", name, rname, err)
+			continue
+		}
+
+		out = append(out, &PkgVersion{
+			Repo:    rname,
+			Version: pkg.Version,
+			Hash:    rpkg.Hash,
+		})
+	}
+
+	if len(repos) > 0 && len(failed) == len(repos) {
+		return nil, fmt.Errorf("no repo could be read: %s", strings.Join(failed, "; "))
+	}
+
+	sortVersions(out)
+	return out, nil
+}
+
+// sortVersions sorts versions highest first. Unparseable versions go last.
+func sortVersions(vers []*PkgVersion) {
+	sort.SliceStable(vers, func(i, j int) bool {
+		vi, erri := semver.Parse(vers[i].Version)
+		vj, errj := semver.Parse(vers[j].Version)
+		switch {
+		case erri != nil || errj != nil:
+			return erri == nil && errj != nil
+		default:
+			return vi.GT(vj)
+		}
+	})
+}
+
+// ResolveRange returns the highest published version of the named package
+// that satisfies the given semver range.
+func (pm *PM) ResolveRange(name, rng string) (*PkgVersion, error) {
+	r, err := ParseVersionRange(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	vers, err := pm.PackageVersions(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range vers {
+		sv, err := semver.Parse(v.Version)
+		if err != nil {
+			VLog("  - skipping %s %s: %s", name, v.Hash, err)
+			continue
+		}
+
+		if r(sv) {
+			VLog("  - resolved %s %s to %s (%s)", name, rng, v.Version, v.Hash)
+			return v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no published version of %s satisfies %s", name, rng)
+}
+
+// ResolveVersion returns the given published version of the named package.
+// The name may be qualified with the repo to look in, as in "repo/name".
+func (pm *PM) ResolveVersion(name, version string) (*PkgVersion, error) {
+	repos := pm.cfg.OrderedRepos()
+	if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+		var found []*RepoRef
+		for _, r := range repos {
+			if r.Name == parts[0] {
+				found = append(found, r)
+			}
+		}
+		if len(found) == 0 {
+			return nil, fmt.Errorf("unknown repo: '%s'", parts[0])
+		}
+		name, repos = parts[1], found
+	}
+
+	vers, err := pm.packageVersions(name, repos)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(vers) == 0 {
+		return nil, fmt.Errorf("could not find package by name: %s", name)
+	}
+
+	var avail []string
+	for _, v := range vers {
+		if sameVersion(v.Version, version) {
+			VLog("  - resolved %s@%s to %s (%s)", name, version, v.Hash, v.Repo)
+			return v, nil
+		}
+		avail = append(avail, v.Version)
+	}
+
+	return nil, fmt.Errorf("version %s of %s not found, available versions: %s", version, name, strings.Join(avail, ", "))
+}
+
+// sameVersion compares two versions, ignoring a leading 'v'
+func sameVersion(a, b string) bool {
+	a, b = strings.TrimPrefix(a, "v"), strings.TrimPrefix(b, "v")
+	if a == b {
+		return true
+	}
+
+	va, erra := semver.Parse(a)
+	vb, errb := semver.Parse(b)
+	return erra == nil && errb == nil && va.Equals(vb)
+}
@@ -0,0 +1,137 @@
+package gxutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blang/semver"
+)
+
+func TestParseVersionRange(t *testing.T) {
+	cases := []struct {
+		rng  string
+		in   []string
+		out  []string
+		fail bool
+	}{
+		{rng: "^1.2.3", in: []string{"1.2.3", "1.9.0"}, out: []string{"1.2.2", "2.0.0"}},
+		{rng: "^0.2.3", in: []string{"0.2.3", "0.2.9"}, out: []string{"0.3.0", "0.2.2"}},
+		{rng: "^0.0.3", in: []string{"0.0.3"}, out: []string{"0.0.4", "0.0.2"}},
+		{rng: "~1.2.3", in: []string{"1.2.3", "1.2.9"}, out: []string{"1.3.0", "1.2.2"}},
+		{rng: "1.x", in: []string{"1.0.0", "1.9.9"}, out: []string{"0.9.0", "2.0.0"}},
+		{rng: "1.2.x", in: []string{"1.2.0", "1.2.9"}, out: []string{"1.3.0", "1.1.9"}},
+		{rng: "1.2", in: []string{"1.2.0"}, out: []string{"1.3.0"}},
+		{rng: "*", in: []string{"0.0.0", "9.9.9"}},
+		{rng: "", in: []string{"0.0.0", "9.9.9"}},
+		{rng: "1.2.3", in: []string{"1.2.3"}, out: []string{"1.2.4"}},
+		{rng: ">=1.2.0 <2.0.0", in: []string{"1.2.0", "1.9.9"}, out: []string{"1.1.9", "2.0.0"}},
+		{rng: ">= 1.2.0 < 2.0.0", in: []string{"1.2.0", "1.9.9"}, out: []string{"1.1.9", "2.0.0"}},
+		{rng: "^ 1.2.3", in: []string{"1.2.3", "1.9.0"}, out: []string{"2.0.0"}},
+		{rng: "~ 1.2.3", in: []string{"1.2.9"}, out: []string{"1.3.0"}},
+		{rng: ">= 1.2", in: []string{"1.2.0"}, out: []string{"1.1.9"}},
+		{rng: ">1.2", in: []string{"1.3.0"}, out: []string{"1.2.9"}},
+		{rng: "> 1.x", in: []string{"2.0.0"}, out: []string{"1.9.9"}},
+		{rng: "<1.2", in: []string{"1.1.9"}, out: []string{"1.2.0"}},
+		{rng: "<= 2", in: []string{"2.9.9"}, out: []string{"3.0.0"}},
+		{rng: "=1.2", in: []string{"1.2.5"}, out: []string{"1.3.0", "1.1.0"}},
+		{rng: "^1.0.0 || ^3.0.0", in: []string{"1.5.0", "3.0.1"}, out: []string{"2.0.0", "4.0.0"}},
+		{rng: "< 2.0.0 || > 3.0.0", in: []string{"1.0.0", "3.0.1"}, out: []string{"2.5.0"}},
+		{rng: "^1.2", in: []string{"1.2.0", "1.9.9"}, out: []string{"1.1.9", "2.0.0"}},
+		{rng: "^1", in: []string{"1.0.0", "1.9.9"}, out: []string{"0.9.9", "2.0.0"}},
+		{rng: "^1.x", in: []string{"1.0.0", "1.9.9"}, out: []string{"2.0.0"}},
+		{rng: "^0.2", in: []string{"0.2.0", "0.2.9"}, out: []string{"0.1.9", "0.3.0"}},
+		{rng: "^0.0", in: []string{"0.0.0", "0.0.9"}, out: []string{"0.1.0"}},
+		{rng: "^0", in: []string{"0.0.0", "0.9.9"}, out: []string{"1.0.0"}},
+		{rng: "^ 1.2", in: []string{"1.5.0"}, out: []string{"2.0.0"}},
+		{rng: "~1.2", in: []string{"1.2.0", "1.2.9"}, out: []string{"1.1.9", "1.3.0"}},
+		{rng: "~1", in: []string{"1.0.0", "1.9.9"}, out: []string{"0.9.9", "2.0.0"}},
+		{rng: "~0.2", in: []string{"0.2.0"}, out: []string{"0.3.0"}},
+		{rng: "^1.2.3-beta", in: []string{"1.2.3-beta", "1.2.3"}, out: []string{"1.2.2", "2.0.0"}},
+		{rng: ">=", fail: true},
+		{rng: ">= ^1.2.3", fail: true},
+		{rng: "^foo", fail: true},
+		{rng: "~1.foo", fail: true},
+		{rng: "1.2.3.4.5", fail: true},
+	}
+
+	for _, c := range cases {
+		r, err := ParseVersionRange(c.rng)
+		if c.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error", c.rng)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %s", c.rng, err)
+			continue
+		}
+
+		for _, v := range c.in {
+			if !r(semver.MustParse(v)) {
+				t.Errorf("%q: expected %s to be in range", c.rng, v)
+			}
+		}
+		for _, v := range c.out {
+			if r(semver.MustParse(v)) {
+				t.Errorf("%q: expected %s to be out of range", c.rng, v)
+			}
+		}
+	}
+}
+
+func TestPackageVersions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	s, err := NewLocalStore(filepath.Join(dir, "store"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := &PM{store: s, cfg: new(Config)}
+
+	repo := filepath.Join(dir, "repo")
+	for _, d := range []string{repo, filepath.Join(dir, "missing")} {
+		if err := os.Mkdir(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idx := `{"version": 2, "packages": {"a": {"hash": "QmA2", "versions": [
+		{"version": "0.1.0", "hash": "QmA1"},
+		{"version": "0.2.0", "hash": "QmA2"}
+	]}}}`
+	if err := ioutil.WriteFile(filepath.Join(repo, RepoIndexFile), []byte(idx), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	root, _, err := buildPath(repo, defaultFormat, s.put)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a repo that was never added to the store
+	missing, err := hashPath(filepath.Join(dir, "missing"), defaultFormat)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	broken := &RepoRef{Name: "broken", Path: "/ipfs/" + missing}
+	good := &RepoRef{Name: "good", Path: "/ipfs/" + root}
+
+	vers, err := pm.packageVersions("a", []*RepoRef{broken, good})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vers) != 2 || vers[0].Hash != "QmA2" || vers[1].Hash != "QmA1" || vers[0].Repo != "good" {
+		t.Fatalf("unexpected versions: %v", vers)
+	}
+
+	if _, err := pm.packageVersions("a", []*RepoRef{broken}); err == nil {
+		t.Fatal("expected an error when no repo can be read")
+	}
+}
@@ -1,6 +1,9 @@
 package gxutil
 
 import (
+	"context"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -80,3 +83,89 @@ func multiaddrToNormal(addr string) (string, error) {
 
 	return host, nil
 }
+
+// ShellStore is a ContentStore backed by the ipfs http api
+type ShellStore struct {
+	sh *sh.Shell
+}
+
+func NewShellStore(s *sh.Shell) *ShellStore {
+	return &ShellStore{sh: s}
+}
+
+func (s *ShellStore) Shell() *sh.Shell {
+	return s.sh
+}
+
+func (s *ShellStore) Get(hash, outdir string) error {
+	return s.sh.Get(hash, outdir)
+}
+
+func (s *ShellStore) List(path string) ([]*Link, error) {
+	links, err := s.sh.List(path)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Link, 0, len(links))
+	for _, l := range links {
+		out = append(out, &Link{
+			Name: l.Name,
+			Hash: l.Hash,
+			Size: l.Size,
+		})
+	}
+	return out, nil
+}
+
+func (s *ShellStore) NewDir() (string, error) {
+	return s.sh.NewObject("unixfs-dir")
+}
+
+func (s *ShellStore) AddFile(r io.Reader) (string, error) {
+	return s.sh.AddNoPin(r)
+}
+
+func (s *ShellStore) AddLink(target string) (string, error) {
+	return s.sh.AddLink(target)
+}
+
+func (s *ShellStore) PatchLink(root, name, child string, create bool) (string, error) {
+	return s.sh.PatchLink(root, name, child, create)
+}
+
+func (s *ShellStore) Resolve(name string) (string, error) {
+	return s.sh.ResolvePath(name)
+}
+
+func (s *ShellStore) Pin(hash string) error {
+	return s.sh.Pin(hash)
+}
+
+func (s *ShellStore) Publish(name, hash string) error {
+	var out struct {
+		Keys []struct {
+			Name string
+			Id   string
+		}
+	}
+	err := s.sh.Request("key/list").Exec(context.Background(), &out)
+	if err != nil {
+		return err
+	}
+
+	// names are published with the key they are derived from
+	for _, k := range out.Keys {
+		if k.Id == name || k.Name == name {
+			_, err := s.sh.PublishWithDetails("/ipfs/"+hash, k.Name, 0, 0, false)
+			return err
+		}
+	}
+
+	return fmt.Errorf("ipfs node has no key for name %s", name)
+}
+
+func (s *ShellStore) Online() bool {
+	_, err := s.sh.ID()
+	return err == nil
+}
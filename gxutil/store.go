@@ -0,0 +1,92 @@
+package gxutil
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+
+	homedir "github.com/mitchellh/go-homedir"
+)
+
+// ContentStore is the backend gx fetches packages from and publishes them
+// to. By default this is an ipfs node (or the public gateway), but it can be
+// swapped out for a local directory to work without any network access.
+type ContentStore interface {
+	// Get writes the object referenced by hash into outdir.
+	Get(hash, outdir string) error
+
+	// List returns the links of the directory object at the given path.
+	List(path string) ([]*Link, error)
+
+	// NewDir returns the hash of an empty directory object.
+	NewDir() (string, error)
+
+	// AddFile adds the contents of r as a file object.
+	AddFile(r io.Reader) (string, error)
+
+	// AddLink adds a symlink object pointing at target.
+	AddLink(target string) (string, error)
+
+	// PatchLink adds a link named name to the directory root, pointing at
+	// child, and returns the hash of the resulting directory. If create is
+	// set, intermediate directories in name are created as needed.
+	PatchLink(root, name, child string, create bool) (string, error)
+
+	// Resolve resolves an /ipfs/ or /ipns/ path to a hash.
+	Resolve(name string) (string, error)
+
+	// Pin makes sure the object referenced by hash is retained.
+	Pin(hash string) error
+
+	// Publish points the mutable name, as used in /ipns/<name> paths, at
+	// the object referenced by hash.
+	Publish(name, hash string) error
+
+	// Online reports whether the store can currently be written to.
+	Online() bool
+}
+
+// Link is an entry in a directory object
+type Link struct {
+	Name string
+	Hash string
+	Size uint64
+}
+
+const (
+	StoreIpfs  = "ipfs"
+	StoreLocal = "local"
+)
+
+// NewContentStore returns the store selected by the 'store' section of the
+// given config, which LoadConfig fills from GX_STORE and GX_STORE_PATH when
+// they are set.
+func NewContentStore(cfg *Config) (ContentStore, error) {
+	var scfg StoreConfig
+	if cfg != nil {
+		scfg = cfg.Store
+	}
+
+	switch scfg.Type {
+	case "", StoreIpfs:
+		return NewShellStore(NewShell()), nil
+	case StoreLocal:
+		p := scfg.Path
+		if p == "" {
+			home, err := homedir.Dir()
+			if err != nil {
+				return nil, err
+			}
+			p = filepath.Join(home, ".gx", "store")
+		}
+
+		p, err := homedir.Expand(p)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewLocalStore(p)
+	default:
+		return nil, fmt.Errorf("unknown content store type: %q", scfg.Type)
+	}
+}
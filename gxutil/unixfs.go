@@ -0,0 +1,531 @@
+package gxutil
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// The types in this file implement just enough of the dag-pb and unixfs
+// formats to let gx build and read the same objects an ipfs node would,
+// without talking to one.
+
+const (
+	unixfsRaw       = 0
+	unixfsDirectory = 1
+	unixfsFile      = 2
+	unixfsSymlink   = 4
+)
+
+// defaults used by 'ipfs add'
+const (
+	chunkSize    = 256 * 1024
+	linksPerNode = 174
+)
+
+type dagLink struct {
+	Name string
+	Hash string
+	Size uint64
+}
+
+type dagNode struct {
+	Links []dagLink
+	Data  []byte
+}
+
+func (n *dagNode) Marshal() ([]byte, error) {
+	links := make([]dagLink, len(n.Links))
+	copy(links, n.Links)
+	sort.SliceStable(links, func(i, j int) bool {
+		return links[i].Name < links[j].Name
+	})
+
+	var out []byte
+	for _, l := range links {
+		c, err := cid.Decode(l.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("invalid link hash %q: %s", l.Hash, err)
+		}
+
+		var lb []byte
+		lb = appendBytesField(lb, 1, c.Bytes())
+		lb = appendBytesField(lb, 2, []byte(l.Name))
+		lb = appendVarintField(lb, 3, l.Size)
+
+		out = appendBytesField(out, 2, lb)
+	}
+
+	if len(n.Data) > 0 {
+		out = appendBytesField(out, 1, n.Data)
+	}
+
+	return out, nil
+}
+
+func unmarshalDagNode(b []byte) (*dagNode, error) {
+	n := new(dagNode)
+	err := readFields(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			n.Data = data
+		case 2:
+			var l dagLink
+			err := readFields(data, func(field int, v uint64, data []byte) error {
+				switch field {
+				case 1:
+					c, err := cid.Cast(data)
+					if err != nil {
+						return err
+					}
+					l.Hash = c.String()
+				case 2:
+					l.Name = string(data)
+				case 3:
+					l.Size = v
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			n.Links = append(n.Links, l)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("malformed dag node: %s", err)
+	}
+
+	return n, nil
+}
+
+type unixfsData struct {
+	Type       int
+	Data       []byte
+	Filesize   *uint64
+	Blocksizes []uint64
+}
+
+func (u *unixfsData) Marshal() []byte {
+	var out []byte
+	out = appendVarintField(out, 1, uint64(u.Type))
+	if len(u.Data) > 0 {
+		out = appendBytesField(out, 2, u.Data)
+	}
+	if u.Filesize != nil {
+		out = appendVarintField(out, 3, *u.Filesize)
+	}
+	for _, bs := range u.Blocksizes {
+		out = appendVarintField(out, 4, bs)
+	}
+	return out
+}
+
+func unmarshalUnixfsData(b []byte) (*unixfsData, error) {
+	u := new(unixfsData)
+	err := readFields(b, func(field int, v uint64, data []byte) error {
+		switch field {
+		case 1:
+			u.Type = int(v)
+		case 2:
+			u.Data = data
+		case 3:
+			fs := v
+			u.Filesize = &fs
+		case 4:
+			u.Blocksizes = append(u.Blocksizes, v)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("malformed unixfs data: %s", err)
+	}
+
+	return u, nil
+}
+
+// dagFormat selects the options 'ipfs add' builds a dag with
+type dagFormat struct {
+	// cidVersion is the version of the CIDs nodes are linked with
+	cidVersion uint64
+
+	// rawLeaves stores file data in raw blocks rather than unixfs nodes
+	rawLeaves bool
+
+	// leafType is the unixfs type of leaf nodes when rawLeaves is not set.
+	// Older versions of ipfs used raw leaves instead of file leaves.
+	leafType int
+}
+
+var (
+	// the default for 'ipfs add'
+	defaultFormat = dagFormat{leafType: unixfsFile}
+
+	// what older versions of ipfs produced
+	legacyFormat = dagFormat{leafType: unixfsRaw}
+
+	// the default for 'ipfs add --cid-version=1'
+	cidV1Format = dagFormat{cidVersion: 1, rawLeaves: true}
+)
+
+// formatsFor returns the dag formats that could have produced the given
+// hash, most likely first. Hashes that gx cannot reproduce yield none.
+func formatsFor(hash string) ([]dagFormat, error) {
+	c, err := cid.Decode(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	pref := c.Prefix()
+	if pref.MhType != mh.SHA2_256 || pref.Codec != cid.DagProtobuf {
+		return nil, nil
+	}
+
+	if pref.Version == 0 {
+		return []dagFormat{defaultFormat, legacyFormat}, nil
+	}
+	return []dagFormat{cidV1Format, {cidVersion: 1, leafType: unixfsFile}}, nil
+}
+
+func (f dagFormat) hash(codec uint64, data []byte) (string, error) {
+	h, err := mh.Sum(data, mh.SHA2_256, -1)
+	if err != nil {
+		return "", err
+	}
+
+	if f.cidVersion == 0 {
+		return cid.NewCidV0(h).String(), nil
+	}
+	return cid.NewCidV1(codec, h).String(), nil
+}
+
+// isRawBlock reports whether hash is the CID of a raw block, which holds
+// file data as is rather than a dag-pb node
+func isRawBlock(hash string) bool {
+	c, err := cid.Decode(hash)
+	if err != nil {
+		return false
+	}
+	return c.Prefix().Codec == cid.Raw
+}
+
+// putFunc is called with every block a dag builder produces. A nil putFunc
+// just computes hashes.
+type putFunc func(hash string, data []byte) error
+
+// putNode serializes and hashes the given node, returning its hash and the
+// cumulative size of the dag below it.
+func putNode(nd *dagNode, f dagFormat, put putFunc) (string, uint64, error) {
+	data, err := nd.Marshal()
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash, err := f.hash(cid.DagProtobuf, data)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if put != nil {
+		if err := put(hash, data); err != nil {
+			return "", 0, err
+		}
+	}
+
+	size := uint64(len(data))
+	for _, l := range nd.Links {
+		size += l.Size
+	}
+
+	return hash, size, nil
+}
+
+func emptyDirNode() *dagNode {
+	return &dagNode{Data: (&unixfsData{Type: unixfsDirectory}).Marshal()}
+}
+
+func symlinkNode(target string) *dagNode {
+	return &dagNode{Data: (&unixfsData{Type: unixfsSymlink, Data: []byte(target)}).Marshal()}
+}
+
+// fileChunk is a built file subtree, along with the amount of file data it
+// holds.
+type fileChunk struct {
+	hash     string
+	size     uint64
+	filesize uint64
+}
+
+// putRaw stores data as a raw block
+func putRaw(data []byte, f dagFormat, put putFunc) (string, uint64, error) {
+	hash, err := f.hash(cid.Raw, data)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if put != nil {
+		if err := put(hash, data); err != nil {
+			return "", 0, err
+		}
+	}
+
+	return hash, uint64(len(data)), nil
+}
+
+// buildFile chunks the data read from r into a balanced unixfs file dag,
+// the same way 'ipfs add' does.
+func buildFile(r io.Reader, f dagFormat, put putFunc) (string, uint64, error) {
+	first, err := readChunk(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	second, err := readChunk(r)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if second == nil {
+		// files that fit in a single chunk are a single leaf
+		if f.rawLeaves {
+			return putRaw(first, f, put)
+		}
+
+		fs := uint64(len(first))
+		data := (&unixfsData{Type: unixfsFile, Data: first, Filesize: &fs}).Marshal()
+		return putNode(&dagNode{Data: data}, f, put)
+	}
+
+	pending := [][]byte{first, second}
+	nextLeaf := func() (*fileChunk, error) {
+		var chunk []byte
+		if len(pending) > 0 {
+			chunk = pending[0]
+			pending = pending[1:]
+		} else {
+			c, err := readChunk(r)
+			if err != nil {
+				return nil, err
+			}
+			if c == nil {
+				return nil, nil
+			}
+			chunk = c
+		}
+
+		fs := uint64(len(chunk))
+		var hash string
+		var size uint64
+		var err error
+		if f.rawLeaves {
+			hash, size, err = putRaw(chunk, f, put)
+		} else {
+			data := (&unixfsData{Type: f.leafType, Data: chunk, Filesize: &fs}).Marshal()
+			hash, size, err = putNode(&dagNode{Data: data}, f, put)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return &fileChunk{hash: hash, size: size, filesize: fs}, nil
+	}
+
+	// fill builds a subtree of the given depth, starting from 'first' (which
+	// may be nil). A nil result means there was no more data.
+	var fill func(first *fileChunk, depth int) (*fileChunk, error)
+	fill = func(first *fileChunk, depth int) (*fileChunk, error) {
+		if depth == 0 {
+			return nextLeaf()
+		}
+
+		var children []*fileChunk
+		if first != nil {
+			children = append(children, first)
+		}
+		for len(children) < linksPerNode {
+			c, err := fill(nil, depth-1)
+			if err != nil {
+				return nil, err
+			}
+			if c == nil {
+				break
+			}
+			children = append(children, c)
+		}
+
+		switch len(children) {
+		case 0:
+			return nil, nil
+		case 1:
+			if first != nil {
+				return first, nil
+			}
+		}
+
+		ufs := &unixfsData{Type: unixfsFile}
+		nd := new(dagNode)
+		var total uint64
+		for _, c := range children {
+			total += c.filesize
+			ufs.Blocksizes = append(ufs.Blocksizes, c.filesize)
+			nd.Links = append(nd.Links, dagLink{Hash: c.hash, Size: c.size})
+		}
+		ufs.Filesize = &total
+		nd.Data = ufs.Marshal()
+
+		hash, size, err := putNode(nd, f, put)
+		if err != nil {
+			return nil, err
+		}
+		return &fileChunk{hash: hash, size: size, filesize: total}, nil
+	}
+
+	root, err := nextLeaf()
+	if err != nil {
+		return "", 0, err
+	}
+
+	for depth := 1; ; depth++ {
+		next, err := fill(root, depth)
+		if err != nil {
+			return "", 0, err
+		}
+		if next == root {
+			return root.hash, root.size, nil
+		}
+		root = next
+	}
+}
+
+// readChunk reads the next chunk of a file, returning nil at EOF
+func readChunk(r io.Reader) ([]byte, error) {
+	buf := make([]byte, chunkSize)
+	n, err := io.ReadFull(r, buf)
+	switch err {
+	case nil, io.ErrUnexpectedEOF:
+		return buf[:n], nil
+	case io.EOF:
+		return nil, nil
+	default:
+		return nil, err
+	}
+}
+
+// buildPath adds the file, symlink or directory tree at p, the same way
+// 'ipfs add -r' would.
+func buildPath(p string, f dagFormat, put putFunc) (string, uint64, error) {
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return "", 0, err
+	}
+
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		target, err := os.Readlink(p)
+		if err != nil {
+			return "", 0, err
+		}
+		return putNode(symlinkNode(target), f, put)
+	case fi.IsDir():
+		return buildDir(p, f, put, nil)
+	default:
+		fi, err := os.Open(p)
+		if err != nil {
+			return "", 0, err
+		}
+		defer fi.Close()
+
+		return buildFile(fi, f, put)
+	}
+}
+
+// buildDir adds the directory at p like buildPath, leaving out the entries
+// for which skip, if set, returns true
+func buildDir(p string, f dagFormat, put putFunc, skip func(name string) bool) (string, uint64, error) {
+	ents, err := ioutil.ReadDir(p)
+	if err != nil {
+		return "", 0, err
+	}
+
+	nd := emptyDirNode()
+	for _, e := range ents {
+		if skip != nil && skip(e.Name()) {
+			continue
+		}
+
+		hash, size, err := buildPath(filepath.Join(p, e.Name()), f, put)
+		if err != nil {
+			return "", 0, err
+		}
+		nd.Links = append(nd.Links, dagLink{Name: e.Name(), Hash: hash, Size: size})
+	}
+	return putNode(nd, f, put)
+}
+
+// hashPath returns the hash ipfs would give the file tree at p
+func hashPath(p string, f dagFormat) (string, error) {
+	hash, _, err := buildPath(p, f, nil)
+	return hash, err
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(b, tmp[:n]...)
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field)<<3)
+	return appendVarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, data []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|2)
+	b = appendVarint(b, uint64(len(data)))
+	return append(b, data...)
+}
+
+// readFields walks the protobuf encoded message in b, calling cb with either
+// the value of each varint field or the contents of each bytes field.
+func readFields(b []byte, cb func(field int, v uint64, data []byte) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return fmt.Errorf("bad field key")
+		}
+		b = b[n:]
+
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			v, n := binary.Uvarint(b)
+			if n <= 0 {
+				return fmt.Errorf("bad varint in field %d", field)
+			}
+			b = b[n:]
+			if err := cb(field, v, nil); err != nil {
+				return err
+			}
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < l {
+				return fmt.Errorf("bad length in field %d", field)
+			}
+			data := b[n : n+int(l)]
+			b = b[n+int(l):]
+			if err := cb(field, 0, data); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported wire type %d in field %d", key&7, field)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,181 @@
+package gxutil
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The expected hashes in these tests were produced by ipfs itself: the
+// small files by 'ipfs add', the 5MB file by the add tests of go-ipfs, and
+// the 10MB file and the raw leaf directory by the importer tests of
+// go-unixfsnode.
+
+// pseudoRandom returns the output of 'random <n> <seed>' from go-random,
+// which the go-ipfs add tests use
+func pseudoRandom(n int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	b := make([]byte, n)
+	for i := 0; i < n; i += 4 {
+		v := r.Uint32()
+		for j := 0; j < 4 && i+j < n; j++ {
+			b[i+j] = byte(v)
+			v >>= 8
+		}
+	}
+	return b
+}
+
+// seededRand returns the output of NewSeededRand from go-ipfs-util
+func seededRand(n int, seed int64) []byte {
+	r := rand.New(rand.NewSource(seed))
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(r.Intn(255))
+	}
+	return b
+}
+
+func TestFileHashes(t *testing.T) {
+	bigfile := pseudoRandom(5*1024*1024, 41)
+	sum := sha1.Sum(bigfile)
+	if hex.EncodeToString(sum[:]) != "5620fb92eb5a49c9986b5c6844efda37e471660e" {
+		t.Fatal("pseudo random data does not match go-random")
+	}
+
+	cases := []struct {
+		name   string
+		data   []byte
+		format dagFormat
+		hash   string
+	}{
+		{"empty", nil, defaultFormat, "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH"},
+		{"small", []byte("hello world\n"), defaultFormat, "QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o"},
+		{"small raw leaf", []byte("hello world\n"), cidV1Format, "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4"},
+		{"multi chunk", bigfile, defaultFormat, "QmSr7FqYkxYWGoSfy8ZiaMWQ5vosb18DQGCzjwEQnVHkTb"},
+		{"multi chunk raw leaves", seededRand(10*1024*1024, 0xdeadbeef), cidV1Format, "bafybeieyxejezqto5xwcxtvh5tskowwxrn3hmbk3hcgredji3g7abtnfkq"},
+	}
+
+	for _, c := range cases {
+		hash, _, err := buildFile(bytes.NewReader(c.data), c.format, nil)
+		if err != nil {
+			t.Fatalf("%s: %s", c.name, err)
+		}
+		if hash != c.hash {
+			t.Errorf("%s: expected %s, got %s", c.name, c.hash, hash)
+		}
+	}
+}
+
+func TestDirectoryHashes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gx-unixfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the fixture of the go-unixfsnode recursive importer test
+	files := map[string]string{
+		"rootDir/a":     "aaa",
+		"rootDir/b/1":   "111",
+		"rootDir/b/2":   "222",
+		"rootDir/c":     "ccc",
+		"links/foo/baz": "some text\n",
+	}
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the fixture of the go-ipfs symlink add test
+	if err := os.Mkdir(filepath.Join(dir, "links/bar"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("files/foo/baz", filepath.Join(dir, "links/bar/baz")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("files/does/not/exist", filepath.Join(dir, "links/bad")); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Mkdir(filepath.Join(dir, "empty"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		path   string
+		format dagFormat
+		hash   string
+	}{
+		{"empty", defaultFormat, "QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn"},
+		{"empty", cidV1Format, "bafybeiczsscdsbs7ffqz55asqdf3smv6klcw3gofszvwlyarci47bgf354"},
+		{"rootDir", cidV1Format, "bafybeihswl3f7pa7fueyayewcvr3clkdz7oetv4jolyejgw26p6l3qzlbm"},
+		{"links", defaultFormat, "QmWdiHKoeSW8G1u7ATCgpx4yMoUhYaJBQGkyPLkS9goYZ8"},
+	}
+
+	for _, c := range cases {
+		hash, err := hashPath(filepath.Join(dir, c.path), c.format)
+		if err != nil {
+			t.Fatalf("%s: %s", c.path, err)
+		}
+		if hash != c.hash {
+			t.Errorf("%s: expected %s, got %s", c.path, c.hash, hash)
+		}
+	}
+}
+
+func TestNodeRoundTrip(t *testing.T) {
+	fs := uint64(3)
+	ufs := &unixfsData{
+		Type:       unixfsFile,
+		Data:       []byte("abc"),
+		Filesize:   &fs,
+		Blocksizes: []uint64{1, 2},
+	}
+	nd := &dagNode{
+		Data: ufs.Marshal(),
+		Links: []dagLink{
+			{Name: "b", Hash: "QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH", Size: 6},
+			{Name: "a", Hash: "bafkreifjjcie6lypi6ny7amxnfftagclbuxndqonfipmb64f2km2devei4", Size: 12},
+		},
+	}
+
+	data, err := nd.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := unmarshalDagNode(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(out.Links) != 2 || out.Links[0].Name != "a" || out.Links[1] != nd.Links[0] {
+		t.Fatalf("links did not survive, or were not sorted: %v", out.Links)
+	}
+	if out.Links[0].Hash != nd.Links[1].Hash {
+		t.Fatalf("cidv1 link came back as %s", out.Links[0].Hash)
+	}
+
+	oufs, err := unmarshalUnixfsData(out.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if oufs.Type != unixfsFile || string(oufs.Data) != "abc" || oufs.Filesize == nil || *oufs.Filesize != 3 {
+		t.Fatalf("unixfs data did not survive: %+v", oufs)
+	}
+	if len(oufs.Blocksizes) != 2 || oufs.Blocksizes[0] != 1 || oufs.Blocksizes[1] != 2 {
+		t.Fatalf("blocksizes did not survive: %v", oufs.Blocksizes)
+	}
+}
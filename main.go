@@ -7,8 +7,8 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"reflect"
 	"sort"
 	"strings"
 	"text/tabwriter"
@@ -60,6 +60,10 @@ func LoadPackageFile(path string) (*gx.Package, error) {
 
 	dephashes := make(map[string]string)
 	for _, dep := range pkg.Dependencies {
+		if dep.Hash == "" {
+			// not resolved from its version range yet
+			continue
+		}
 		if pkgname := dephashes[dep.Hash]; pkgname != "" {
 			return nil, fmt.Errorf("have two packages with a hash of %s (%s, %s)", dep.Hash, dep.Name, pkgname)
 		}
@@ -70,16 +74,6 @@ func LoadPackageFile(path string) (*gx.Package, error) {
 }
 
 func main() {
-	cfg, err := gx.LoadConfig()
-	if err != nil {
-		log.Fatal(err)
-	}
-
-	pm, err = gx.NewPM(cfg)
-	if err != nil {
-		log.Fatal(err)
-	}
-
 	app := cli.NewApp()
 	app.Authors = []*cli.Author{
 		&cli.Author{
@@ -92,6 +86,14 @@ func main() {
 			Name:  "verbose",
 			Usage: "print verbose logging information",
 		},
+		&cli.BoolFlag{
+			Name:  "no-verify",
+			Usage: "do not check fetched content against its hash",
+		},
+		&cli.BoolFlag{
+			Name:  "allow-dep-hooks",
+			Usage: "run the gxHooks scripts of dependencies",
+		},
 	}
 	app.Before = func(c *cli.Context) error {
 		log.Verbose = c.Bool("verbose")
@@ -102,23 +104,49 @@ func main() {
 		}
 		cwd = gcwd
 
-		return nil
+		cfg, err := gx.LoadConfig()
+		if err != nil {
+			if c.Args().First() != "config" {
+				log.Fatal(err)
+			}
+
+			// still allow fixing the config with 'gx config'
+			log.Error(err)
+			cfg = new(gx.Config)
+		}
+
+		if c.Bool("no-verify") {
+			cfg.NoVerify = true
+		}
+		if c.Bool("allow-dep-hooks") {
+			cfg.AllowDepHooks = true
+		}
+
+		pm, err = gx.NewPM(cfg)
+		return err
 	}
 
 	app.Usage = "gx is a packaging tool that uses ipfs"
 
 	app.Commands = []*cli.Command{
+		&BuildCommand,
+		&CacheCommand,
 		&CleanCommand,
+		&ConfigCommand,
 		&DepsCommand,
 		&GetCommand,
 		&ImportCommand,
 		&DiffCommand,
 		&InitCommand,
 		&InstallCommand,
+		&LockCommand,
 		&LockInstallCommand,
+		&OutdatedCommand,
 		&PublishCommand,
 		&ReleaseCommand,
 		&RepoCommand,
+		&RmCommand,
+		&RunCommand,
 		&UpdateCommand,
 		&VersionCommand,
 		&ViewCommand,
@@ -141,6 +169,23 @@ func checkLastPubVer() string {
 	return string(parts[0])
 }
 
+// lastPubHash returns the hash recorded in .gx/lastpubver
+func lastPubHash() (string, error) {
+	out, err := ioutil.ReadFile(filepath.Join(cwd, ".gx", "lastpubver"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("package has not been published yet, no .gx/lastpubver found")
+		}
+		return "", err
+	}
+
+	parts := bytes.Split(out, []byte{':'})
+	if len(parts) < 2 {
+		return "", fmt.Errorf("unrecognized format on .gx/lastpubver")
+	}
+	return string(bytes.TrimSpace(parts[1])), nil
+}
+
 var PublishCommand = cli.Command{
 	Name:  "publish",
 	Usage: "publish a package",
@@ -189,7 +234,7 @@ func doPublish(pkg *gx.Package) (string, error) {
 		return "", fmt.Errorf("ipfs daemon isn't running")
 	}
 
-	err := gx.TryRunHook("pre-publish", pkg.Language, pkg.SubtoolRequired)
+	err := gx.TryRunHookOpts("pre-publish", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg})
 	if err != nil {
 		return "", err
 	}
@@ -206,7 +251,7 @@ func doPublish(pkg *gx.Package) (string, error) {
 		return hash, err
 	}
 
-	err = gx.TryRunHook("post-publish", pkg.Language, pkg.SubtoolRequired, hash)
+	err = gx.TryRunHookOpts("post-publish", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg}, hash)
 	return hash, err
 }
 
@@ -239,10 +284,19 @@ var ImportCommand = cli.Command{
 
 EXAMPLE
   > gx import QmUAQaWbKxGCUTuoQVvvicbQNZ9APF5pDGWyAZSe93AtKH
+  > gx import go-log
+  > gx import go-log@1.5.2
   > gx import github.com/libp2p/go-libp2p
+  > gx import github.com/libp2p/go-libp2p@v6.0.0
 
-    In the last example, Gx will check the ".gx/lastpubver"
-    file in the repository to find which hash to import.
+    Names are looked up in the configured repos, and
+    name@version picks a version from their history.
+
+    In the last examples, Gx will check the ".gx/lastpubver"
+    file in the repository, on the default branch or the given
+    branch or tag, to find which hash to import. Hosts other
+    than github.com and gitlab.com can be configured in the
+    'resolvers' section of .gxrc.
 `,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
@@ -254,6 +308,10 @@ EXAMPLE
 			Name:  "local",
 			Usage: "install packages locally (equal to --global=false)",
 		},
+		&cli.StringFlag{
+			Name:  "range",
+			Usage: "import the newest version of the named package matching this semver range",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		if c.NArg() == 0 {
@@ -278,9 +336,22 @@ EXAMPLE
 			return fmt.Errorf("package %s already imported as %s", cdep.Hash, cdep.Name)
 		}
 
-		dephash, err := pm.ResolveDepName(depname)
-		if err != nil {
-			return err
+		var dephash string
+		if rng := c.String("range"); rng != "" {
+			if strings.Contains(depname, "@") {
+				return fmt.Errorf("cannot use --range with a versioned reference: %s", depname)
+			}
+
+			v, err := pm.ResolveRange(depname, rng)
+			if err != nil {
+				return err
+			}
+			dephash = v.Hash
+		} else {
+			dephash, err = pm.ResolveDepName(depname)
+			if err != nil {
+				return err
+			}
 		}
 
 		ipath, err := gx.InstallPath(pkg.Language, "", global)
@@ -308,6 +379,7 @@ EXAMPLE
 			Hash:    dephash,
 			Name:    npkg.Name,
 			Version: npkg.Version,
+			Range:   c.String("range"),
 		}
 
 		pkg.Dependencies = append(pkg.Dependencies, ndep)
@@ -316,7 +388,16 @@ EXAMPLE
 			return fmt.Errorf("writing pkgfile: %s", err)
 		}
 
-		err = gx.TryRunHook("post-import", npkg.Language, npkg.SubtoolRequired, dephash)
+		opts := &gx.HookOptions{
+			Pkg:         npkg,
+			Dir:         filepath.Join(ipath, "gx", "ipfs", dephash, npkg.Name),
+			InstallPath: ipath,
+			Global:      global,
+
+			Dependency:    true,
+			AllowDepHooks: pm.DepHooksAllowed(),
+		}
+		err = gx.TryRunHookOpts("post-import", npkg.Language, npkg.SubtoolRequired, opts, dephash)
 		if err != nil {
 			return fmt.Errorf("running post-import: %s", err)
 		}
@@ -325,6 +406,128 @@ EXAMPLE
 	},
 }
 
+var RmCommand = cli.Command{
+	Name:      "rm",
+	Usage:     "remove a package dependency",
+	ArgsUsage: "<name|hash>",
+	Description: `Remove a dependency from package.json.
+
+   The 'pre-remove' and 'post-remove' hooks are run around the change so
+   language tools can undo any changes made on import.
+
+   If other packages in the dependency tree still import the removed
+   package, a warning is printed for each of them.
+
+   Pass '--clean' to also delete the package from the local vendor
+   directory if nothing else references it.
+
+EXAMPLE:
+   > gx rm go-multihash
+   > gx rm QmUAQaWbKxGCUTuoQVvvicbQNZ9APF5pDGWyAZSe93AtKH
+`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "clean",
+			Usage: "delete the package from the vendor directory if unused",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("rm takes exactly one package reference")
+		}
+
+		root, err := gx.GetPackageRoot()
+		if err != nil {
+			return err
+		}
+
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		ref := c.Args().First()
+		dep := pkg.FindDep(ref)
+		if dep == nil {
+			return fmt.Errorf("no dependency referenced by %s", ref)
+		}
+
+		var others []*gx.Dependency
+		for _, d := range pkg.Dependencies {
+			if d != dep {
+				others = append(others, d)
+			}
+		}
+
+		rest := &gx.PackageBase{Name: pkg.Name, Language: pkg.Language, Dependencies: others}
+		err = rmImportersCheck(rest, dep, []string{pkg.Name}, make(map[string]struct{}))
+		if err != nil {
+			log.Error("checking for other importers: ", err)
+		}
+
+		err = gx.TryRunHookOpts("pre-remove", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg}, dep.Hash)
+		if err != nil {
+			return err
+		}
+
+		pkg.Dependencies = others
+		err = gx.SavePackageFile(pkg, filepath.Join(root, PkgFileName))
+		if err != nil {
+			return fmt.Errorf("writing package file: %s", err)
+		}
+		log.Log("removed %s (%s)", dep.Name, dep.Hash)
+
+		err = gx.TryRunHookOpts("post-remove", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg}, dep.Hash)
+		if err != nil {
+			return err
+		}
+
+		if !c.Bool("clean") {
+			return nil
+		}
+
+		used, err := pm.EnumerateDependencies(pkg)
+		if err != nil {
+			return err
+		}
+
+		if _, ok := used[dep.Hash]; ok {
+			log.Log("not deleting %s, it is still used by other dependencies", dep.Hash)
+			return nil
+		}
+
+		ipath, err := gx.InstallPath(pkg.Language, root, false)
+		if err != nil {
+			return err
+		}
+		if !filepath.IsAbs(ipath) {
+			ipath = filepath.Join(root, ipath)
+		}
+
+		pkgdir := filepath.Join(ipath, "gx", "ipfs", dep.Hash)
+		log.VLog("deleting %s", pkgdir)
+		return os.RemoveAll(pkgdir)
+	},
+}
+
+// rmImportersCheck warns about every package under ipkg that imports the
+// dependency being removed
+func rmImportersCheck(ipkg *gx.PackageBase, rdep *gx.Dependency, chain []string, skip map[string]struct{}) error {
+	return ipkg.ForEachDep(func(dep *gx.Dependency, hash string, pkg *gx.Package) error {
+		if hash == rdep.Hash || dep.Name == rdep.Name {
+			log.Log("warning: %s is still imported by %s (as %s)", rdep.Name, strings.Join(chain, "/"), hash)
+			return nil
+		}
+
+		if _, ok := skip[hash]; ok {
+			return nil
+		}
+		skip[hash] = struct{}{}
+
+		return rmImportersCheck(&pkg.PackageBase, rdep, append(chain, dep.Name), skip)
+	})
+}
+
 var InstallCommand = cli.Command{
 	Name:    "install",
 	Usage:   "install this package",
@@ -371,7 +574,7 @@ var InstallCommand = cli.Command{
 				return err
 			}
 
-			err = gx.TryRunHook("req-check", pkg.Language, pkg.SubtoolRequired, cwd)
+			err = gx.TryRunHookOpts("req-check", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg, Global: global}, cwd)
 			if err != nil {
 				return err
 			}
@@ -381,10 +584,23 @@ var InstallCommand = cli.Command{
 				return err
 			}
 
+			resolved, err := resolveRangeDeps(pkg)
+			if err != nil {
+				return err
+			}
+
 			err = pm.InstallDeps(pkg, ipath)
 			if err != nil {
 				return fmt.Errorf("install deps: %s", err)
 			}
+
+			if resolved {
+				err := gx.SavePackageFile(pkg, PkgFileName)
+				if err != nil {
+					return err
+				}
+				return refreshLockFile(pkg)
+			}
 			return nil
 		}
 
@@ -403,7 +619,7 @@ var InstallCommand = cli.Command{
 				log.VLog("%s resolved to %s", p, phash)
 			}
 
-			ndep, err := pm.ImportPackage(ipath, p)
+			ndep, err := pm.ImportPackage(ipath, phash)
 			if err != nil {
 				return fmt.Errorf("importing package '%s': %s", p, err)
 			}
@@ -516,6 +732,12 @@ EXAMPLE:
    $ export OLDHASH=QmdTTcAwxWhHLruoZtowxuqua1e5GVkYzxziiYPDn4vWJb
    $ export NEWHASH=QmPZ6gM12JxshKzwSyrhbEmyrsi7UaMrnoQZL6mdrzSfh1
    $ gx update $OLDHASH $NEWHASH
+
+   Update every dependency with a version range (or only the ones named)
+   to the newest version published in your repos that matches it:
+
+   $ gx update --within-range
+   $ gx update --within-range myPkg
 `,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
@@ -531,6 +753,10 @@ EXAMPLE:
 			Name:  "with-deps",
 			Usage: "experimental feature to recursively update child deps too",
 		},
+		&cli.BoolFlag{
+			Name:  "within-range",
+			Usage: "update deps to the newest version matching their range",
+		},
 	},
 	Action: func(c *cli.Context) error {
 		pkg, err := LoadPackageFile(PkgFileName)
@@ -538,6 +764,20 @@ EXAMPLE:
 			log.Fatal("error: ", err)
 		}
 
+		if c.Bool("within-range") {
+			global := c.Bool("global")
+			if c.Bool("local") {
+				global = false
+			}
+
+			ipath, err := gx.InstallPath(pkg.Language, cwd, global)
+			if err != nil {
+				return err
+			}
+
+			return updateWithinRange(pkg, c.Args().Slice(), ipath)
+		}
+
 		var existing, target string
 		switch c.NArg() {
 		case 0:
@@ -595,7 +835,7 @@ continue?`, olddep.Name, olddep.Hash, npkg.Name, trgthash)
 		}
 
 		log.VLog("running pre update hook...")
-		err = gx.TryRunHook("pre-update", pkg.Language, pkg.SubtoolRequired, existing)
+		err = gx.TryRunHookOpts("pre-update", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg}, existing)
 		if err != nil {
 			return err
 		}
@@ -623,7 +863,7 @@ continue?`, olddep.Name, olddep.Hash, npkg.Name, trgthash)
 		}
 
 		log.VLog("running post update hook...")
-		err = gx.TryRunHook("post-update", pkg.Language, pkg.SubtoolRequired, oldhash, trgthash)
+		err = gx.TryRunHookOpts("post-update", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg}, oldhash, trgthash)
 		if err != nil {
 			return err
 		}
@@ -634,19 +874,96 @@ continue?`, olddep.Name, olddep.Hash, npkg.Name, trgthash)
 	},
 }
 
+var OutdatedCommand = cli.Command{
+	Name:  "outdated",
+	Usage: "check dependencies for newer published versions",
+	Description: `outdated resolves the latest published version of each dependency
+   and prints it next to the version currently in use, along with how
+   the two differ (major, minor, patch, ...).
+
+   Dependencies with a 'gx.dvcsimport' on a known code forge (github.com,
+   gitlab.com, or any host in the 'resolvers' config) are resolved through
+   the .gx/lastpubver file in their repository, read from a local git
+   checkout when there is one. All others are looked up by name in the
+   configured repos, in priority order. The 'source' field of the '--json'
+   output tells where the latest version was found.
+
+   By default only dependencies with a newer version are shown, pass
+   '--all' to show every dependency.
+`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "r",
+			Usage: "check deps recursively",
+		},
+		&cli.BoolFlag{
+			Name:  "all",
+			Usage: "also show deps that are up to date or could not be resolved",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print output as json",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		deps, err := pm.CheckOutdated(pkg, c.Bool("r"))
+		if err != nil {
+			return err
+		}
+
+		var out []*gx.OutdatedDep
+		for _, od := range deps {
+			if od.Error != "" {
+				log.VLog("could not resolve latest %s: %s", od.Name, od.Error)
+			}
+			if c.Bool("all") || od.Outdated() {
+				out = append(out, od)
+			}
+		}
+
+		sort.SliceStable(out, func(i, j int) bool {
+			return out[i].Name < out[j].Name
+		})
+
+		if c.Bool("json") {
+			if out == nil {
+				out = []*gx.OutdatedDep{}
+			}
+			jsonPrint(out)
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCURRENT\tLATEST\tDELTA\tIMPORTER")
+		for _, od := range out {
+			latest := od.Latest
+			if latest == "" {
+				latest = "?"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", od.Name, od.Current, latest, od.Delta, od.Importer)
+		}
+		return w.Flush()
+	},
+}
+
 func updateCollisionCheck(ipkg *gx.Package, idep *gx.Dependency, trgt string, chain []string, skip map[string]struct{}) error {
-	return ipkg.ForEachDep(func(dep *gx.Dependency, pkg *gx.Package) error {
-		if _, ok := skip[dep.Hash]; ok {
+	return ipkg.ForEachDep(func(dep *gx.Dependency, hash string, pkg *gx.Package) error {
+		if _, ok := skip[hash]; ok {
 			return nil
 		}
 
 		if dep == idep {
 			return nil
 		}
-		skip[dep.Hash] = struct{}{}
+		skip[hash] = struct{}{}
 
-		if (dep.Name == idep.Name && dep.Hash != trgt) || (dep.Hash == idep.Hash && dep.Name != idep.Name) {
-			log.Log("dep %s also imports %s (%s)", strings.Join(chain, "/"), dep.Name, dep.Hash)
+		if (dep.Name == idep.Name && hash != trgt) || (hash == idep.Hash && dep.Name != idep.Name) {
+			log.Log("dep %s also imports %s (%s)", strings.Join(chain, "/"), dep.Name, hash)
 			return nil
 		}
 
@@ -922,6 +1239,107 @@ var depCheckCommand = cli.Command{
 	},
 }
 
+var LockCommand = cli.Command{
+	Name:  "lock",
+	Usage: "write a lockfile for the current dependency tree",
+	Description: `lock writes out a gx-lock.json pinning every package in the
+   dependency tree of this package, for use with 'gx lock-install'.
+
+   All dependencies must be installed first.
+
+   use '--check' to verify that the existing lockfile is up to date without
+   writing anything.
+`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "check",
+			Usage: "fail if the existing lockfile does not match the dependency tree",
+		},
+	},
+	Subcommands: []*cli.Command{
+		&lockMigrateCommand,
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		root, err := gx.GetPackageRoot()
+		if err != nil {
+			return err
+		}
+		lckpath := filepath.Join(root, gx.LckFileName)
+
+		lck, err := pm.LockForPackage(pkg)
+		if err != nil {
+			return err
+		}
+
+		if !c.Bool("check") {
+			return gx.SaveLockFile(lck, lckpath)
+		}
+
+		var cur gx.LockFile
+		if err := gx.LoadLockFile(&cur, lckpath); err != nil {
+			return err
+		}
+
+		if !reflect.DeepEqual(&cur, lck) {
+			return fmt.Errorf("%s is out of date, run 'gx lock' to update it", gx.LckFileName)
+		}
+
+		log.Log("%s is up to date", gx.LckFileName)
+		return nil
+	},
+}
+
+var lockMigrateCommand = cli.Command{
+	Name:  "migrate",
+	Usage: "upgrade gx-lock.json to the current lockfile format",
+	Description: `migrate rewrites an old gx-lock.json in place, adding the name,
+   version, language and content digest of every locked package.
+
+   Each locked package is fetched into the lockfile cache to read its
+   information.
+`,
+	Action: func(c *cli.Context) error {
+		root, err := gx.GetPackageRoot()
+		if err != nil {
+			return err
+		}
+		lckpath := filepath.Join(root, gx.LckFileName)
+
+		var lck gx.LockFile
+		if err := gx.LoadLockFile(&lck, lckpath); err != nil {
+			return err
+		}
+
+		if lck.LockVersion == gx.LockVersion {
+			log.Log("%s is already at version %d", gx.LckFileName, gx.LockVersion)
+			return nil
+		}
+
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		if err := pm.MigrateLock(&lck, root); err != nil {
+			return err
+		}
+		lck.Name = pkg.Name
+		lck.Version = pkg.Version
+
+		if err := gx.SaveLockFile(&lck, lckpath); err != nil {
+			return err
+		}
+
+		log.Log("migrated %s to version %d", gx.LckFileName, gx.LockVersion)
+		return nil
+	},
+}
+
 var LockInstallCommand = cli.Command{
 	Name:  "lock-install",
 	Usage: "Install deps from lockfile into vendor",
@@ -995,7 +1413,7 @@ var CleanCommand = cli.Command{
 		}
 
 		for _, di := range dirinfos {
-			if !strings.HasPrefix(di.Name(), "Qm") {
+			if !gx.IsHash(di.Name()) {
 				continue
 			}
 			_, keep := good[di.Name()]
@@ -1060,6 +1478,8 @@ var DepsCommand = cli.Command{
 		&depFindCommand,
 		&depStatsCommand,
 		&depDupesCommand,
+		&depWhyCommand,
+		&depDedupeCommand,
 		&depCheckCommand,
 		&depDotCommand,
 	},
@@ -1094,7 +1514,7 @@ var DepsCommand = cli.Command{
 			}
 		} else {
 			for _, d := range pkg.Dependencies {
-				deps = append(deps, d.Hash)
+				deps = append(deps, pkg.Overrides.Apply(d).Hash)
 			}
 		}
 
@@ -1178,6 +1598,7 @@ var depDupesCommand = cli.Command{
 
 		for len(pkgs) > 0 {
 			for _, d := range pkgs[0].Dependencies {
+				d = root.Overrides.Apply(d)
 				if set[d.Name] == nil {
 					set[d.Name] = map[string][]string{}
 				}
@@ -1228,6 +1649,151 @@ var depDupesCommand = cli.Command{
 	},
 }
 
+var depWhyCommand = cli.Command{
+	Name:      "why",
+	Usage:     "print the import chains that pull a package into the tree",
+	ArgsUsage: "<name|hash>",
+	Description: `why prints every chain of imports leading from this package to
+   the given package, shortest first. When referenced by name, chains to
+   every hash imported under that name are printed.
+
+EXAMPLE:
+   > gx deps why go-multihash
+   mypkg -> go-multihash (QmYf7ng2hG5XBtJA3tN34DQ2GUN5HNksEw1rLDkmr6vGku)
+   mypkg -> go-ipfs-util -> go-multihash (QmYf7ng2hG5XBtJA3tN34DQ2GUN5HNksEw1rLDkmr6vGku)
+`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print output as json",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if c.NArg() != 1 {
+			return fmt.Errorf("must be passed exactly one argument")
+		}
+
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		dt, err := genDepsTree(pm, pkg)
+		if err != nil {
+			return err
+		}
+
+		target := c.Args().First()
+		chains := dt.chainsTo(target)
+		if len(chains) == 0 {
+			return fmt.Errorf("%s is not in the dependency tree of %s", target, pkg.Name)
+		}
+
+		if c.Bool("json") {
+			jsonPrint(chains)
+			return nil
+		}
+
+		for _, chain := range chains {
+			last := chain[len(chain)-1]
+			fmt.Printf("%s -> %s (%s)\n", pkg.Name, chainString(chain), last.Hash)
+		}
+		return nil
+	},
+}
+
+var depDedupeCommand = cli.Command{
+	Name:  "dedupe",
+	Usage: "update the dependency tree to import every package under a single hash",
+	Description: `dedupe finds packages imported under multiple hashes and updates
+   every importer to use the same one, republishing packages in the
+   dependency tree as needed.
+
+   By default the highest version of each package is kept. Use '--pick'
+   to choose a different one, by hash or version.
+
+   The plan is printed and confirmed before anything is changed.
+
+EXAMPLE:
+   > gx deps dedupe --pick go-log=1.4.0
+`,
+	Flags: []cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "pick",
+			Usage: "keep the given version or hash of a package (name=version|hash)",
+		},
+		&cli.BoolFlag{
+			Name:  "dry-run",
+			Usage: "only print the plan",
+		},
+		&cli.BoolFlag{
+			Name:  "yes",
+			Usage: "do not ask for confirmation",
+		},
+		&cli.BoolFlag{
+			Name:  "global",
+			Value: true,
+			Usage: "install republished packages in global namespace",
+		},
+		&cli.BoolFlag{
+			Name:  "local",
+			Usage: "install republished packages locally (equal to --global=false)",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		picks := make(map[string]string)
+		for _, p := range c.StringSlice("pick") {
+			parts := strings.SplitN(p, "=", 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("invalid pick %q, expected name=version|hash", p)
+			}
+			picks[parts[0]] = parts[1]
+		}
+
+		plan, err := planDedupe(pkg, picks)
+		if err != nil {
+			return err
+		}
+
+		if len(plan.replace) == 0 {
+			log.Log("no duplicate packages found")
+			return nil
+		}
+
+		plan.print()
+		if c.Bool("dry-run") {
+			return nil
+		}
+
+		if !c.Bool("yes") && !yesNoPrompt("continue?", false) {
+			return nil
+		}
+
+		if len(plan.republish) > 0 && !pm.ShellOnline() {
+			return fmt.Errorf("ipfs daemon isn't running")
+		}
+
+		global := c.Bool("global")
+		if c.Bool("local") {
+			global = false
+		}
+		pm.SetGlobal(global)
+		pm.ProgMeter = progmeter.NewProgMeter(true)
+
+		ipath, err := gx.InstallPath(pkg.Language, cwd, global)
+		if err != nil {
+			return err
+		}
+
+		return runDedupe(pkg, plan, ipath)
+	},
+}
+
 var depStatsCommand = cli.Command{
 	Name:  "stats",
 	Usage: "print out statistics about this packages dependency tree",
@@ -1274,7 +1840,7 @@ func depBundleForPkg(pkg *gx.Package) (string, error) {
 }
 
 func depBundleForPkgRec(pkg *gx.Package, done map[string]bool) (string, error) {
-	obj, err := pm.Shell().NewObject("unixfs-dir")
+	obj, err := pm.Store().NewDir()
 	if err != nil {
 		return "", err
 	}
@@ -1285,7 +1851,7 @@ func depBundleForPkgRec(pkg *gx.Package, done map[string]bool) (string, error) {
 		}
 
 		log.Log("processing dep: ", dep.Name)
-		nobj, err := pm.Shell().PatchLink(obj, dep.Name+"-"+dep.Hash, dep.Hash, false)
+		nobj, err := pm.Store().PatchLink(obj, dep.Name+"-"+dep.Hash, dep.Hash, false)
 		if err != nil {
 			return "", err
 		}
@@ -1301,7 +1867,7 @@ func depBundleForPkgRec(pkg *gx.Package, done map[string]bool) (string, error) {
 			return "", err
 		}
 
-		nobj, err = pm.Shell().PatchLink(nobj, dep.Name+"-"+dep.Hash+"-deps", child, false)
+		nobj, err = pm.Store().PatchLink(nobj, dep.Name+"-"+dep.Hash+"-deps", child, false)
 		if err != nil {
 			return "", err
 		}
@@ -1413,9 +1979,20 @@ var ReleaseCommand = cli.Command{
 }
 
 var TestCommand = cli.Command{
-	Name:            "test",
-	Usage:           "run package tests",
-	Description:     `Runs a pre-test setup hook, the test command itself, and then a post-test cleanup hook.`,
+	Name:  "test",
+	Usage: "run package tests",
+	Description: `Runs a pre-test setup hook, the test command itself, and then a post-test cleanup hook.
+
+   The test command is the 'test' field of package.json, run in a shell with
+   the same GX_* environment variables as the releaseCmd, or the test hook of
+   the language subtool if it isn't set. Any arguments are passed on to it,
+   and gx exits with its exit code. The tests run in the package root, and the
+   post-test hook runs even if they fail.
+
+   With --deps as the first argument, the tests of every installed dependency
+   are run instead, each in the directory of the dependency.
+`,
+	ArgsUsage:       "[--deps] [args...]",
 	SkipFlagParsing: true,
 	Action: func(c *cli.Context) error {
 		pkg, err := LoadPackageFile(PkgFileName)
@@ -1423,25 +2000,96 @@ var TestCommand = cli.Command{
 			return err
 		}
 
-		err = gx.TryRunHook("pre-test", pkg.Language, pkg.SubtoolRequired)
+		args := c.Args().Slice()
+		if len(args) > 0 && args[0] == "--deps" {
+			return testDeps(pkg, args[1:])
+		}
+
+		root, err := gx.GetPackageRoot()
 		if err != nil {
 			return err
 		}
 
-		var testErr error
-		if pkg.Test != "" {
-			testErr = fmt.Errorf("don't support running custom test script yet, bug whyrusleeping")
-		} else {
-			testErr = gx.TryRunHook("test", pkg.Language, pkg.SubtoolRequired, c.Args().Slice()...)
-		}
+		return scriptExit("tests", runTests(pkg, root, false, args))
+	},
+}
+
+// runTests runs the tests of pkg in dir, wrapped by the pre-test and
+// post-test hooks. The post-test hook runs even if the tests fail, whose
+// error is returned first. The gxHooks scripts of a dependency only run if
+// allow_dep_hooks is set.
+func runTests(pkg *gx.Package, dir string, dep bool, args []string) error {
+	opts := &gx.HookOptions{
+		Pkg:           pkg,
+		Dir:           dir,
+		SubtoolDir:    dir,
+		Dependency:    dep,
+		AllowDepHooks: pm.DepHooksAllowed(),
+	}
+	err := gx.TryRunHookOpts("pre-test", pkg.Language, pkg.SubtoolRequired, opts)
+	if err != nil {
+		return err
+	}
 
-		err = gx.TryRunHook("post-test", pkg.Language, pkg.SubtoolRequired)
+	var testErr error
+	if pkg.Test != "" {
+		log.VLog("running test command: %s", pkg.Test)
+		cmd := gx.ScriptCommand(pkg.Test, dir, gx.ScriptEnv(&pkg.PackageBase, ""), args...)
+		testErr = cmd.Run()
+	} else {
+		testErr = gx.TryRunHookOpts("test", pkg.Language, pkg.SubtoolRequired, opts, args...)
+	}
+
+	err = gx.TryRunHookOpts("post-test", pkg.Language, pkg.SubtoolRequired, opts)
+	if testErr != nil {
 		if err != nil {
-			return err
+			log.Error(err)
 		}
-
 		return testErr
-	},
+	}
+
+	return err
+}
+
+// testDeps runs the tests of every installed dependency of pkg, each in its
+// own directory, reporting the ones that failed
+func testDeps(pkg *gx.Package, args []string) error {
+	seen := make(map[string]bool)
+	var failed []string
+
+	var walk func(p *gx.Package) error
+	walk = func(p *gx.Package) error {
+		return p.ForEachDep(func(dep *gx.Dependency, hash string, dpkg *gx.Package) error {
+			if seen[hash] {
+				return nil
+			}
+			seen[hash] = true
+
+			dir, err := gx.PackageDir(pkg.Language, hash)
+			if err != nil {
+				return fmt.Errorf("testing %s: %s", dep.Name, err)
+			}
+
+			log.Log("testing %s %s in %s", dep.Name, dpkg.Version, dir)
+			if err := runTests(dpkg, dir, true, args); err != nil {
+				log.Error("tests of %s failed: %s", dep.Name, err)
+				failed = append(failed, dep.Name)
+			}
+
+			return walk(dpkg)
+		})
+	}
+
+	if err := walk(pkg); err != nil {
+		return err
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("tests failed for %d of %d dependencies: %s", len(failed), len(seen), strings.Join(failed, ", "))
+	}
+
+	log.Log("tests passed for all %d dependencies", len(seen))
+	return nil
 }
 
 func runRelease(pkg *gx.Package, hash string) error {
@@ -1449,20 +2097,6 @@ func runRelease(pkg *gx.Package, hash string) error {
 		return nil
 	}
 
-	cmd := exec.Command("sh", "-c", pkg.ReleaseCmd)
-	cmd.Env = append(
-		os.Environ(),
-		"VERSION="+pkg.Version, // deprecated.
-		"GX_VERSION="+pkg.Version,
-		"GX_NAME="+pkg.Name,
-		"GX_LANGUAGE="+pkg.Language,
-		"GX_LICENSE="+pkg.License,
-		"GX_AUTHOR="+pkg.Author,
-		"GX_HASH="+hash,
-	)
-	cmd.Stderr = os.Stderr
-	cmd.Stdout = os.Stdout
-	cmd.Stdin = os.Stdin
-
+	cmd := gx.ScriptCommand(pkg.ReleaseCmd, "", gx.ScriptEnv(&pkg.PackageBase, hash))
 	return cmd.Run()
 }
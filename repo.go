@@ -2,9 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"sort"
+	"strings"
 	"text/tabwriter"
 
 	hd "github.com/mitchellh/go-homedir"
@@ -37,21 +39,36 @@ var RepoCommand = cli.Command{
 		&RepoRmCommand,
 		&RepoListCommand,
 		&RepoQueryCommand,
+		&RepoSearchCommand,
 		&RepoUpdateCommand,
+		&RepoPublishCommand,
 	},
 }
 
 var RepoAddCommand = cli.Command{
 	Name:  "add",
 	Usage: "add a naming repository",
+	Description: `Add a repo to the local set of the current project, the global set,
+   or the global set of community repos. When several repos list a package,
+   its name resolves through the repo with the highest priority. Local repos
+   default to priority 300, global repos to 200 and community repos to 100.
+`,
 	Flags: []cli.Flag{
 		&cli.BoolFlag{
 			Name:  "global",
 			Usage: "add repository to global set",
 		},
+		&cli.BoolFlag{
+			Name:  "community",
+			Usage: "add repository to global set of community repos",
+		},
+		&cli.IntFlag{
+			Name:  "priority",
+			Usage: "priority of the repo when resolving names, higher wins",
+		},
 	},
 	Action: func(c *cli.Context) error {
-		global := c.Bool("global")
+		global := c.Bool("global") || c.Bool("community")
 		cfp, err := cfgPath(global)
 		if err != nil {
 			return err
@@ -74,13 +91,20 @@ var RepoAddCommand = cli.Command{
 			return fmt.Errorf("finding repo: %s", err)
 		}
 
-		if global {
+		switch {
+		case c.Bool("community"):
+			cfg.CommunityRepos[name] = rpath
+		case global:
 			cfg.Repos[name] = rpath
-		} else {
+		default:
 			cfg.ExtraRepos[name] = rpath
 		}
 
-		return gx.WriteConfig(cfg, cfp)
+		if c.IsSet("priority") {
+			cfg.RepoPriorities[name] = c.Int("priority")
+		}
+
+		return gx.SaveConfigRepos(cfg, cfp)
 	},
 }
 
@@ -111,24 +135,33 @@ var RepoRmCommand = cli.Command{
 		name := c.Args().First()
 
 		if global {
-			if _, ok := cfg.Repos[name]; !ok {
+			_, ok := cfg.Repos[name]
+			_, cok := cfg.CommunityRepos[name]
+			if !ok && !cok {
 				return fmt.Errorf("no repo named %s", name)
 			}
 			delete(cfg.Repos, name)
+			delete(cfg.CommunityRepos, name)
 		} else {
 			if _, ok := cfg.ExtraRepos[name]; !ok {
 				return fmt.Errorf("no repo named %s", name)
 			}
 			delete(cfg.ExtraRepos, name)
 		}
+		delete(cfg.RepoPriorities, name)
 
-		return gx.WriteConfig(cfg, cfp)
+		return gx.SaveConfigRepos(cfg, cfp)
 	},
 }
 
 var RepoListCommand = cli.Command{
-	Name:  "list",
-	Usage: "list tracked repos or packages in a repo",
+	Name:      "list",
+	Usage:     "list tracked repos or packages in a repo",
+	ArgsUsage: "[repo [package]]",
+	Description: `With no arguments, list tracked repos. Given a repo, list the
+   packages in it. Given a repo and a package, show the version history of
+   that package, if the repo keeps one.
+`,
 	Action: func(c *cli.Context) error {
 		cfg, err := gx.LoadConfig()
 		if err != nil {
@@ -146,6 +179,10 @@ var RepoListCommand = cli.Command{
 			return fmt.Errorf("no such repo: %s", rname)
 		}
 
+		if c.NArg() > 1 {
+			return repoListPackage(r, c.Args().Get(1))
+		}
+
 		repo, err := pm.FetchRepo(r, true)
 		if err != nil {
 			return err
@@ -156,6 +193,42 @@ var RepoListCommand = cli.Command{
 	},
 }
 
+func repoListPackage(rpath, name string) error {
+	idx, err := pm.FetchRepoIndex(rpath, true)
+	if err != nil {
+		return err
+	}
+
+	p, ok := idx.Packages[name]
+	if !ok {
+		return fmt.Errorf("package %s not found in repo", name)
+	}
+
+	if p.Description != "" {
+		fmt.Println(p.Description)
+	}
+	if len(p.Keywords) > 0 {
+		fmt.Printf("keywords: %s\n", strings.Join(p.Keywords, ", "))
+	}
+	if p.Deprecated {
+		fmt.Println("DEPRECATED")
+	}
+	fmt.Printf("latest: %s\n", p.Hash)
+
+	if len(p.Versions) == 0 {
+		Log("repo does not keep version history")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+	fmt.Fprintln(w, "\nVERSION\tHASH\tPUBLISHED")
+	for i := len(p.Versions) - 1; i >= 0; i-- {
+		v := p.Versions[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Version, v.Hash, v.Published)
+	}
+	return w.Flush()
+}
+
 func tabPrintSortedMap(headers []string, m map[string]string) {
 	var names []string
 	for k := range m {
@@ -178,6 +251,9 @@ func tabPrintSortedMap(headers []string, m map[string]string) {
 var RepoQueryCommand = cli.Command{
 	Name:  "query",
 	Usage: "search for a package in repos",
+	Description: `List the repos that have a package of the given name, in the order
+   they are used to resolve it, and which one the name resolves to.
+`,
 	Action: func(c *cli.Context) error {
 		if !c.Args().Present() {
 			return fmt.Errorf("must specify search criteria")
@@ -185,20 +261,102 @@ var RepoQueryCommand = cli.Command{
 
 		searcharg := c.Args().First()
 
-		out, err := pm.QueryRepos(searcharg)
+		out, err := pm.QueryReposOrdered(searcharg)
 		if err != nil {
 			return err
 		}
 
-		if len(out) > 0 {
-			tabPrintSortedMap([]string{"repo", "ref"}, out)
-		} else {
+		if len(out) == 0 {
 			return fmt.Errorf("not found")
 		}
+
+		w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+		fmt.Fprintln(w, "repo\tref\ttier\tpriority")
+		for _, m := range out {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d\n", m.Name, m.Hash, m.Tier, m.Priority)
+		}
+		w.Flush()
+
+		fmt.Printf("\n%s resolves to %s: %s\n", searcharg, out[0].Hash, repoQueryReason(out))
 		return nil
 	},
 }
 
+// repoQueryReason explains why the first match wins
+func repoQueryReason(out []*gx.RepoMatch) string {
+	best := out[0]
+	if len(out) == 1 {
+		return fmt.Sprintf("only repo %s has it", best.Name)
+	}
+
+	next := out[1]
+	switch {
+	case best.Priority > next.Priority:
+		src := fmt.Sprintf("default for %s repos", best.Tier)
+		if best.Explicit {
+			src = "set in repo_priorities"
+		}
+		return fmt.Sprintf("repo %s has the highest priority (%d, %s)", best.Name, best.Priority, src)
+	case best.Tier != next.Tier:
+		return fmt.Sprintf("repo %s ties with %s on priority %d, %s repos win over %s repos", best.Name, next.Name, best.Priority, best.Tier, next.Tier)
+	default:
+		return fmt.Sprintf("repo %s ties with %s on priority %d and tier, the first by name wins", best.Name, next.Name, best.Priority)
+	}
+}
+
+var RepoSearchCommand = cli.Command{
+	Name:      "search",
+	Usage:     "search packages in repos by name, keyword or description",
+	ArgsUsage: "<term>...",
+	Description: `Search the packages of all configured repos. Packages must match
+   every term, and are listed best match first.
+
+   Searches run against a local index of the repos, which is built the first
+   time a repo is searched. Pass --update to refresh it.
+`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "update",
+			Usage: "refresh the search index before searching",
+		},
+		&cli.BoolFlag{
+			Name:  "json",
+			Usage: "print results as json",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() && !c.Bool("update") {
+			return fmt.Errorf("must specify search terms")
+		}
+
+		idx, err := pm.UpdateSearchIndex(c.Bool("update"))
+		if err != nil {
+			return err
+		}
+
+		if !c.Args().Present() {
+			return nil
+		}
+
+		res := idx.Search(c.Args().Slice())
+		if c.Bool("json") {
+			jsonPrint(res)
+			return nil
+		}
+
+		if len(res) == 0 {
+			return fmt.Errorf("no packages found")
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+		fmt.Fprintln(w, "NAME\tVERSION\tREPO\tHASH\tDESCRIPTION")
+		for _, r := range res {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.Version, r.Repo, r.Hash, r.Description)
+		}
+		return w.Flush()
+	},
+}
+
 var RepoUpdateCommand = cli.Command{
 	Name:  "update",
 	Usage: "update cached versions of repos",
@@ -247,3 +405,128 @@ var RepoUpdateCommand = cli.Command{
 		return nil
 	},
 }
+
+// setRepoPath points the named repo at a new path in every config file
+// that has it at old
+func setRepoPath(name, old, rpath string) error {
+	srcs, err := gx.LoadConfigSources()
+	if err != nil {
+		return err
+	}
+
+	var found bool
+	for _, src := range srcs {
+		var changed bool
+		for _, tier := range []string{"repos", "extra_repos", "community_repos"} {
+			repos, ok := src.Values[tier].(map[string]interface{})
+			if !ok || repos[name] != old {
+				continue
+			}
+			repos[name] = rpath
+			changed = true
+		}
+
+		if !changed {
+			continue
+		}
+
+		if err := gx.SaveConfigFile(src.Values, src.Name); err != nil {
+			return err
+		}
+		Log("updated repo %s in %s", name, src.Name)
+		found = true
+	}
+
+	if !found {
+		return fmt.Errorf("repo %s is not set to %s in any config file, point it at %s yourself", name, old, rpath)
+	}
+	return nil
+}
+
+var RepoPublishCommand = cli.Command{
+	Name:      "publish",
+	Usage:     "add a package to a repo",
+	ArgsUsage: "<repo> [hash]",
+	Description: `Add a published package to the named repo, or update its entry
+   to the new version. If no hash is given, the hash in .gx/lastpubver is
+   used.
+
+   The updated repo gets a new hash. Repos configured with an /ipfs/ path
+   are pointed at it in the config file they come from, the way 'repo add'
+   writes them. To point an ipns repo at it, pass --republish. This requires
+   the key of the ipns name.
+`,
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "republish",
+			Usage: "publish the updated repo to its ipns name",
+		},
+	},
+	Action: func(c *cli.Context) error {
+		if !c.Args().Present() {
+			return fmt.Errorf("must specify repo to publish to")
+		}
+
+		cfg, err := gx.LoadConfig()
+		if err != nil {
+			return err
+		}
+
+		rname := c.Args().First()
+		rpath, ok := cfg.GetRepos()[rname]
+		if !ok {
+			return fmt.Errorf("no such repo: %s", rname)
+		}
+
+		hash := c.Args().Get(1)
+		if hash == "" {
+			hash, err = lastPubHash()
+			if err != nil {
+				return err
+			}
+		}
+
+		if !pm.ShellOnline() {
+			return fmt.Errorf("ipfs daemon isn't running")
+		}
+
+		dir, err := ioutil.TempDir("", "gx-repo")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		pkg, err := pm.GetPackageTo(hash, filepath.Join(dir, hash))
+		if err != nil {
+			return fmt.Errorf("fetching package: %s", err)
+		}
+
+		root, err := pm.Store().Resolve(rpath)
+		if err != nil {
+			return fmt.Errorf("resolving repo: %s", err)
+		}
+
+		nroot, err := pm.AddToRepo(root, &pkg.PackageBase, hash)
+		if err != nil {
+			return err
+		}
+		Log("added %s %s to repo %s, new repo hash: %s", pkg.Name, pkg.Version, rname, nroot)
+
+		if !strings.HasPrefix(rpath, "/ipns/") {
+			// an ipfs path can't be republished, the config has to follow
+			return setRepoPath(rname, rpath, "/ipfs/"+nroot)
+		}
+
+		if !c.Bool("republish") {
+			Log("run with --republish to point %s at it", rpath)
+			return nil
+		}
+
+		err = pm.PublishRepo(rpath, nroot)
+		if err != nil {
+			return err
+		}
+		Log("published %s to %s", nroot, rpath)
+		return nil
+	},
+}
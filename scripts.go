@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	cli "github.com/urfave/cli/v2"
+	gx "github.com/whyrusleeping/gx/gxutil"
+	log "github.com/whyrusleeping/stump"
+)
+
+var BuildCommand = cli.Command{
+	Name:  "build",
+	Usage: "build the package",
+	Description: `Runs a pre-build hook, the build command itself, and then a post-build hook.
+
+   The build command is the 'build' field of package.json, run in a shell with
+   the same GX_* environment variables as the releaseCmd, or the build hook of
+   the language subtool if it isn't set. Any arguments are passed on to it,
+   and gx exits with its exit code. The build runs in the package root, and the
+   post-build hook runs even if it fails.
+`,
+	ArgsUsage:       "[args...]",
+	SkipFlagParsing: true,
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		root, err := gx.GetPackageRoot()
+		if err != nil {
+			return err
+		}
+
+		return scriptExit("build", runBuild(pkg, root, c.Args().Slice()))
+	},
+}
+
+// runBuild builds pkg in dir, wrapped by the pre-build and post-build hooks.
+// Like post-test, the post-build hook runs even if the build fails, whose
+// error is returned first.
+func runBuild(pkg *gx.Package, dir string, args []string) error {
+	opts := &gx.HookOptions{Pkg: pkg, Dir: dir, SubtoolDir: dir}
+	err := gx.TryRunHookOpts("pre-build", pkg.Language, pkg.SubtoolRequired, opts)
+	if err != nil {
+		return err
+	}
+
+	var buildErr error
+	if pkg.Build != "" {
+		log.VLog("running build command: %s", pkg.Build)
+		cmd := gx.ScriptCommand(pkg.Build, dir, gx.ScriptEnv(&pkg.PackageBase, ""), args...)
+		buildErr = cmd.Run()
+	} else {
+		buildErr = gx.TryRunHookOpts("build", pkg.Language, pkg.SubtoolRequired, opts, args...)
+	}
+
+	err = gx.TryRunHookOpts("post-build", pkg.Language, pkg.SubtoolRequired, opts)
+	if buildErr != nil {
+		if err != nil {
+			log.Error(err)
+		}
+		return buildErr
+	}
+
+	return err
+}
+
+var RunCommand = cli.Command{
+	Name:  "run",
+	Usage: "run a script of the package",
+	Description: `Runs the named command of the 'scripts' section of package.json in a
+   shell, with the same GX_* environment variables as the releaseCmd, and
+   GX_SCRIPT set to its name. Like the build, it runs in the package root. Any
+   further arguments are passed on to it, and gx exits with its exit code.
+   Without a name, the scripts are listed.
+
+EXAMPLE:
+   > gx set --in-json scripts '{"lint": "golint ./..."}'
+   > gx run lint
+`,
+	ArgsUsage:       "[<script> [args...]]",
+	SkipFlagParsing: true,
+	Action: func(c *cli.Context) error {
+		pkg, err := LoadPackageFile(PkgFileName)
+		if err != nil {
+			return err
+		}
+
+		if !c.Args().Present() {
+			return listScripts(pkg)
+		}
+
+		name := c.Args().First()
+		script, ok := pkg.Scripts[name]
+		if !ok {
+			return fmt.Errorf("no script named %q in %s, have: %s", name, PkgFileName, strings.Join(scriptNames(pkg), ", "))
+		}
+
+		root, err := gx.GetPackageRoot()
+		if err != nil {
+			return err
+		}
+
+		log.VLog("running script %s: %s", name, script)
+		env := append(gx.ScriptEnv(&pkg.PackageBase, ""), "GX_SCRIPT="+name)
+		cmd := gx.ScriptCommand(script, root, env, c.Args().Tail()...)
+		return scriptExit(name, cmd.Run())
+	},
+}
+
+func scriptNames(pkg *gx.Package) []string {
+	var names []string
+	for n := range pkg.Scripts {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func listScripts(pkg *gx.Package) error {
+	w := tabwriter.NewWriter(os.Stdout, 12, 4, 1, ' ', 0)
+	for _, n := range scriptNames(pkg) {
+		fmt.Fprintf(w, "%s\t%s\n", n, pkg.Scripts[n])
+	}
+	return w.Flush()
+}
+
+// scriptExit makes gx exit with the exit code of a failed script, err being
+// the result of running it
+func scriptExit(what string, err error) error {
+	if ee, ok := err.(*exec.ExitError); ok {
+		log.Error("%s failed: %s", what, ee)
+		return cli.Exit("", ee.ExitCode())
+	}
+	return err
+}
@@ -0,0 +1,49 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test the local directory content store"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none &&
+	mkdir -p a/sub &&
+	echo "hello world" > a/sub/file &&
+	ln -s sub/file a/link
+'
+
+test_expect_success "publish a to the local store" '
+	pkgA=$(publish_package a) &&
+	test -n "$pkgA" &&
+	test -d store/blocks
+'
+
+test_expect_success "publishing again gives the same hash" '
+	pkgA2=$(pkg_run a gx publish -f | awk "{ print \$6 }") &&
+	test "$pkgA" = "$pkgA2"
+'
+
+test_expect_success "import a into b from the local store" '
+	pkg_run b gx import $pkgA
+'
+
+test_expect_success "imported files look good" '
+	echo "hello world" > file_exp &&
+	test_cmp file_exp b/vendor/gx/ipfs/$pkgA/a/sub/file &&
+	echo "sub/file" > link_exp &&
+	readlink b/vendor/gx/ipfs/$pkgA/a/link > link_out &&
+	test_cmp link_exp link_out
+'
+
+test_expect_success "get fails for hashes missing from the store" '
+	test_must_fail gx get QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn 2> get_err
+'
+
+test_done
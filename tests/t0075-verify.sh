@@ -0,0 +1,40 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test verifying fetched content"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+# a CIDv1 using sha2-512, which gx can't compute
+other=bafybgqasb6k2tsk2i6iw4mzsje7plmlogzzkr4iee6ag27row43xl4do5icwgy3vhkyshllnvzp6rhajuupx266z2slo6pkkuco2qlqhsr3w6
+
+test_expect_success "setup a package stored under a hash gx can't compute" '
+	make_package a none &&
+	pkgA=$(publish_package a) &&
+	cp store/blocks/$pkgA store/blocks/$other
+'
+
+test_expect_success "content under the hash gx computes is verified" '
+	gx get -o got $pkgA &&
+	test -f got/a/package.json
+'
+
+test_expect_success "content gx can't verify is refused" '
+	test_must_fail gx get -o unverified $other > get_out 2>&1 &&
+	test_should_contain "cannot verify content of $other" get_out &&
+	test_should_contain "use --no-verify to skip the check" get_out &&
+	test ! -e unverified
+'
+
+test_expect_success "--no-verify fetches it anyway" '
+	gx --no-verify get -o unverified $other &&
+	test -f unverified/a/package.json
+'
+
+test_done
@@ -0,0 +1,100 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test gx lockfiles"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none &&
+	echo "some code" > a/code
+'
+
+test_expect_success "publish a and import it into b" '
+	pkgA=$(publish_package a) &&
+	pkg_run b gx import $pkgA
+'
+
+test_expect_success "gx lock succeeds" '
+	pkg_run b gx lock
+'
+
+test_expect_success "lockfile looks good" '
+	jq -r ".lockVersion" b/gx-lock.json > version_out &&
+	echo 2 > version_exp &&
+	test_cmp version_exp version_out &&
+	jq -r ".deps.none.a.ref" b/gx-lock.json > ref_out &&
+	echo "/ipfs/$pkgA/a" > ref_exp &&
+	test_cmp ref_exp ref_out &&
+	jq -r ".deps.none.a.digest" b/gx-lock.json > digest_out &&
+	test -s digest_out
+'
+
+test_expect_success "gx lock works without the content store" '
+	mv b/gx-lock.json lock_online &&
+	GX_STORE_PATH="$(pwd)/missing" pkg_run b gx lock &&
+	test_cmp lock_online b/gx-lock.json
+'
+
+test_expect_success "gx lock --check passes" '
+	pkg_run b gx lock --check
+'
+
+test_expect_success "lock-install verifies and installs the lockfile" '
+	mkdir c &&
+	cp b/package.json b/gx-lock.json c/ &&
+	pkg_run c gx lock-install &&
+	echo "some code" > code_exp &&
+	test_cmp code_exp c/vendor/a/code
+'
+
+test_expect_success "lock-install refuses a wrong digest" '
+	mkdir d &&
+	cp b/package.json d/ &&
+	jq ".deps.none.a.digest = \"QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn\"" b/gx-lock.json > d/gx-lock.json &&
+	test_must_fail pkg_run d gx lock-install > install_err 2>&1 &&
+	test_should_contain "content hash mismatch" install_err
+'
+
+test_expect_success "content failing verification is not left in the cache" '
+	test ! -e "d/.gx/cache/ipfs/$pkgA/a"
+'
+
+test_expect_success "lock-install --no-verify skips verification" '
+	pkg_run d gx --no-verify lock-install &&
+	test_cmp code_exp d/vendor/a/code
+'
+
+test_expect_success "tamper with the content of a in the store" '
+	blk=$(grep -l "some code" store/blocks/*) &&
+	sed -i "s/some code/evil code/" $blk
+'
+
+test_expect_success "fetching tampered content by hash fails" '
+	test_must_fail gx get -o a_out $pkgA > get_err 2>&1 &&
+	test_should_contain "refusing to install" get_err &&
+	test ! -e a_out
+'
+
+test_expect_success "fetching tampered content by path fails" '
+	mkdir e &&
+	cp b/package.json b/gx-lock.json e/ &&
+	test_must_fail pkg_run e gx lock-install > install_err 2>&1 &&
+	test_should_contain "refusing to install" install_err
+'
+
+test_expect_success "the no_verify config option skips verification" '
+	echo "{\"no_verify\": true}" > e/.gxrc &&
+	pkg_run e gx lock-install &&
+	echo "evil code" > evil_exp &&
+	test_cmp evil_exp e/vendor/a/code
+'
+
+test_done
@@ -0,0 +1,50 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test removing dependencies"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none &&
+	make_package c none
+'
+
+test_expect_success "import a into b and c" '
+	pkgA=$(publish_package a) &&
+	pkg_run b gx import $pkgA &&
+	pkg_run c gx import $pkgA
+'
+
+test_expect_success "gx rm removes the dependency" '
+	pkg_run b gx rm a &&
+	jq -r ".gxDependencies | length" b/package.json > deps_out &&
+	echo 0 > deps_exp &&
+	test_cmp deps_exp deps_out
+'
+
+test_expect_success "gx rm keeps the package files without --clean" '
+	test -d b/vendor/gx/ipfs/$pkgA
+'
+
+test_expect_success "gx rm --clean from a subdirectory uses the package root" '
+	mkdir -p c/sub/dir &&
+	pkg_run c/sub/dir gx rm --clean a &&
+	jq -r ".gxDependencies | length" c/package.json > deps_out &&
+	test_cmp deps_exp deps_out &&
+	test ! -e c/sub/dir/package.json &&
+	test ! -e c/vendor/gx/ipfs/$pkgA
+'
+
+test_expect_success "gx rm fails for unknown dependencies" '
+	test_must_fail pkg_run c gx rm a
+'
+
+test_done
@@ -0,0 +1,58 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test printing why a package is imported"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none &&
+	make_package c none &&
+	make_package d none &&
+	make_package root none
+'
+
+test_expect_success "build a diamond: a and b both import c, which imports d" '
+	pkgD=$(publish_package d) &&
+	pkg_run c gx import $pkgD &&
+	pkgC=$(publish_package c) &&
+	pkg_run a gx import $pkgC &&
+	pkgA=$(publish_package a) &&
+	pkg_run b gx import $pkgC &&
+	pkgB=$(publish_package b)
+'
+
+test_expect_success "root imports b, a and d" '
+	pkg_run root gx import $pkgB &&
+	pkg_run root gx import $pkgA &&
+	pkg_run root gx import $pkgD
+'
+
+test_expect_success "every chain is printed, shortest first" '
+	pkg_run root gx deps why d > why_out &&
+	echo "root -> d ($pkgD)" > why_exp &&
+	echo "root -> a -> c -> d ($pkgD)" >> why_exp &&
+	echo "root -> b -> c -> d ($pkgD)" >> why_exp &&
+	test_cmp why_exp why_out
+'
+
+test_expect_success "packages can be given by hash" '
+	pkg_run root gx deps why $pkgC > why_out &&
+	echo "root -> a -> c ($pkgC)" > why_exp &&
+	echo "root -> b -> c ($pkgC)" >> why_exp &&
+	test_cmp why_exp why_out
+'
+
+test_expect_success "packages outside the tree are an error" '
+	test_must_fail pkg_run root gx deps why nope > why_err 2>&1 &&
+	test_should_contain "nope is not in the dependency tree of root" why_err
+'
+
+test_done
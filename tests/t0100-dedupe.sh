@@ -0,0 +1,118 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test deduplicating the dependency tree"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none &&
+	make_package c none
+'
+
+test_expect_success "publish three versions of a" '
+	pkg_run a gx version 0.1.0 &&
+	pkgA1=$(publish_package a) &&
+	pkg_run a gx version 0.2.0 &&
+	pkgA2=$(publish_package a) &&
+	pkg_run a gx version 0.3.0 &&
+	pkgA3=$(publish_package a)
+'
+
+test_expect_success "b imports the first version of a" '
+	pkg_run b gx import $pkgA1 &&
+	pkgB=$(publish_package b)
+'
+
+test_expect_success "c imports b and the second version of a" '
+	pkg_run c gx import $pkgB &&
+	pkg_run c gx import $pkgA2
+'
+
+test_expect_success "dedupe plans to keep the highest version" '
+	pkg_run c gx deps dedupe --dry-run > dedupe_out &&
+	test_should_contain "package a: keeping 0.2.0 $pkgA2" dedupe_out &&
+	test_should_contain "replacing 0.1.0 $pkgA1 (imported by b)" dedupe_out &&
+	test_should_contain "packages to republish:" dedupe_out &&
+	test_should_contain "  - b $pkgB" dedupe_out
+'
+
+test_expect_success "--pick keeps the given version" '
+	pkg_run c gx deps dedupe --dry-run --pick a=0.1.0 > dedupe_out &&
+	test_should_contain "package a: keeping 0.1.0 $pkgA1" dedupe_out &&
+	test_should_contain "replacing 0.2.0 $pkgA2 (imported by c)" dedupe_out
+'
+
+test_expect_success "dedupe republishes b to import the kept a" '
+	pkg_run c gx deps dedupe --yes &&
+	jq -r ".gxDependencies[] | select(.name == \"b\") | .hash" c/package.json > hash_out &&
+	test_must_fail grep $pkgB hash_out &&
+	pkg_run c gx deps why a > why_out &&
+	test_must_fail grep $pkgA1 why_out
+'
+
+test_expect_success "nothing is left to dedupe" '
+	pkg_run c gx deps dedupe --dry-run > dedupe_out &&
+	test_should_contain "no duplicate packages found" dedupe_out
+'
+
+test_expect_success "e imports b and the second version of a, and overrides the a of b" '
+	make_package e none &&
+	pkg_run e gx import $pkgB &&
+	pkg_run e gx import $pkgA2 &&
+	jq ".gxOverrides = {\"$pkgA1\": \"$pkgA3\"}" e/package.json > e/package.json.new &&
+	mv e/package.json.new e/package.json &&
+	pkg_run e gx install
+'
+
+test_expect_success "dedupe plans with the overriding hash" '
+	pkg_run e gx deps dedupe --dry-run > dedupe_out &&
+	test_should_contain "keeping 0.3.0 $pkgA3" dedupe_out &&
+	test_should_contain "replacing 0.2.0 $pkgA2" dedupe_out
+'
+
+test_expect_success "dedupe keeps the overriding hash" '
+	pkg_run e gx deps dedupe --yes &&
+	jq -r ".gxDependencies[] | select(.name == \"a\") | .hash" e/package.json > hash_out &&
+	echo $pkgA3 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_expect_success "d imports b, a newer a, and overrides the a of b" '
+	make_package d none &&
+	pkg_run d gx import $pkgB &&
+	pkg_run d gx import $pkgA3 &&
+	jq ".gxOverrides = {\"$pkgA1\": \"$pkgA2\"}" d/package.json > d/package.json.new &&
+	mv d/package.json.new d/package.json &&
+	pkg_run d gx install
+'
+
+test_expect_success "dedupe plans to retarget the override" '
+	pkg_run d gx deps dedupe --dry-run > dedupe_out &&
+	test_should_contain "replacing 0.2.0 $pkgA2" dedupe_out &&
+	test_must_fail grep "packages to republish" dedupe_out
+'
+
+test_expect_success "dedupe retargets the override" '
+	pkg_run d gx deps dedupe --yes &&
+	jq -r ".gxOverrides[\"$pkgA1\"]" d/package.json > hash_out &&
+	echo $pkgA3 > hash_exp &&
+	test_cmp hash_exp hash_out &&
+	pkg_run d gx deps dedupe --dry-run > dedupe_out &&
+	test_should_contain "no duplicate packages found" dedupe_out
+'
+
+test_expect_success "b is not republished for an override of the root" '
+	jq -r ".gxDependencies[] | select(.name == \"b\") | .hash" d/package.json > hash_out &&
+	echo $pkgB > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_done
@@ -0,0 +1,53 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test overriding dependencies anywhere in the tree"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none &&
+	make_package c none
+'
+
+test_expect_success "b imports the first version of a" '
+	pkg_run a gx version 0.1.0 &&
+	pkgA1=$(publish_package a) &&
+	pkg_run a gx version 0.2.0 &&
+	pkgA2=$(publish_package a) &&
+	pkg_run b gx import $pkgA1 &&
+	pkgB=$(publish_package b)
+'
+
+test_expect_success "c imports b and overrides the a of b" '
+	pkg_run c gx import $pkgB &&
+	jq ".gxOverrides = {\"$pkgA1\": \"$pkgA2\"}" c/package.json > c/package.json.new &&
+	mv c/package.json.new c/package.json &&
+	pkg_run c gx install
+'
+
+test_expect_success "the tree shows the overriding hash" '
+	pkg_run c gx deps why a > why_out &&
+	echo "c -> b -> a ($pkgA2)" > why_exp &&
+	test_cmp why_exp why_out &&
+	pkg_run c gx deps -r -q > deps_out &&
+	test_should_contain $pkgA2 deps_out &&
+	test_must_fail grep $pkgA1 deps_out
+'
+
+test_expect_success "overrides by name apply too" '
+	jq ".gxOverrides = {\"a\": \"$pkgA2\"}" c/package.json > c/package.json.new &&
+	mv c/package.json.new c/package.json &&
+	pkg_run c gx install &&
+	pkg_run c gx deps why a > why_out &&
+	test_cmp why_exp why_out
+'
+
+test_done
@@ -0,0 +1,90 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test importing packages by semver range"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+# the empty directory, which publishing puts in the store
+empty_dir=QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn
+
+# "hello world\n", which is not in the store
+missing=QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o
+
+# publish_version <version> publishes that version of package a and adds it
+# to the repo, printing its hash
+publish_version() {
+	pkg_run a gx version $1 &&
+	hash=$(publish_package a) &&
+	pkg_run a gx repo publish myrepo $hash > /dev/null &&
+	echo $hash
+}
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none
+'
+
+test_expect_success "publish versions of a to a repo" '
+	publish_package a > /dev/null &&
+	pkg_run a gx repo add myrepo /ipfs/$empty_dir &&
+	pkgA1=$(publish_version 0.1.0) &&
+	pkgA2=$(publish_version 0.2.0) &&
+	pkgA3=$(publish_version 1.0.0)
+'
+
+test_expect_success "b uses the repo, and one that can not be read" '
+	cp a/.gxrc b/.gxrc &&
+	jq ".extra_repos.broken = \"/ipfs/$missing\"" b/.gxrc > b/.gxrc.new &&
+	mv b/.gxrc.new b/.gxrc
+'
+
+test_expect_success "import with a range skips the broken repo" '
+	(cd b && gx import --range ">= 0.1.0 < 1.0" a) > import_out 2>&1 &&
+	test_should_contain "warning: skipping repo broken" import_out &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA2 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_expect_success "ranges with spaced operators work" '
+	pkg_run b gx rm a &&
+	(cd b && gx import --range "^ 0.1.0" a) &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA1 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_expect_success "carets and tildes of partial versions work" '
+	pkg_run b gx rm a &&
+	(cd b && gx import --range "^0.1" a) &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA1 > hash_exp &&
+	test_cmp hash_exp hash_out &&
+	pkg_run b gx rm a &&
+	(cd b && gx import --range "~1" a) &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA3 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_expect_success "import fails when no version is in range" '
+	pkg_run b gx rm a &&
+	test_must_fail pkg_run b gx import --range ">1" a
+'
+
+test_expect_success "import by name skips the broken repo" '
+	pkg_run b gx import a > import_out 2>&1 &&
+	test_should_contain "warning: skipping repo broken" import_out &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA3 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_done
@@ -0,0 +1,66 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test publishing packages to a repo"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+
+# the empty directory, which publishing puts in the store
+empty_dir=QmUNLLsPACCz1vLxQVkXqqLX5R1X345qqfHbsf67hvA3Nn
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none
+'
+
+test_expect_success "publish a and add an empty repo" '
+	pkgA1=$(publish_package a) &&
+	pkg_run a gx repo add myrepo /ipfs/$empty_dir
+'
+
+test_expect_success "repo publish updates the repo in the config" '
+	pkg_run a gx repo publish myrepo > publish_out &&
+	repo=$(awk "/new repo hash/ { print \$NF }" publish_out) &&
+	jq -r ".extra_repos.myrepo" a/.gxrc > repo_out &&
+	echo /ipfs/$repo > repo_exp &&
+	test_cmp repo_exp repo_out
+'
+
+test_expect_success "publishing again adds to the updated repo" '
+	pkg_run a gx version 0.2.0 &&
+	pkgA2=$(publish_package a) &&
+	pkg_run a gx repo publish myrepo &&
+	pkg_run a gx repo list myrepo a > list_out &&
+	test_should_contain $pkgA1 list_out &&
+	test_should_contain $pkgA2 list_out
+'
+
+test_expect_success "repo publish updates global repos in the global config" '
+	pkg_run b gx repo add --global globalrepo /ipfs/$empty_dir &&
+	pkg_run b gx repo publish globalrepo $pkgA2 > publish_out &&
+	repo=$(awk "/new repo hash/ { print \$NF }" publish_out) &&
+	jq -r ".repos.globalrepo" "$HOME/.gxrc" > repo_out &&
+	echo /ipfs/$repo > repo_exp &&
+	test_cmp repo_exp repo_out &&
+	test ! -e b/.gxrc
+'
+
+test_expect_success "repo add and rm leave the rest of the config alone" '
+	echo "{\"user\": {\"name\": \"b\"}, \"cache\": {\"ttl\": \"2h\"}}" > b/.gxrc &&
+	pkg_run b gx repo add --priority 5 otherrepo /ipfs/$empty_dir &&
+	jq -c -S . b/.gxrc > add_out &&
+	echo "{\"cache\":{\"ttl\":\"2h\"},\"extra_repos\":{\"otherrepo\":\"/ipfs/$empty_dir\"},\"repo_priorities\":{\"otherrepo\":5},\"user\":{\"name\":\"b\"}}" > add_exp &&
+	test_cmp add_exp add_out &&
+	pkg_run b gx repo rm otherrepo &&
+	jq -c -S . b/.gxrc > rm_out &&
+	echo "{\"cache\":{\"ttl\":\"2h\"},\"user\":{\"name\":\"b\"}}" > rm_exp &&
+	test_cmp rm_exp rm_out
+'
+
+test_done
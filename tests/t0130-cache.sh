@@ -0,0 +1,57 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test the resolution cache"
+
+. lib/test-lib.sh
+
+export XDG_CACHE_HOME="$(pwd)/cache"
+
+hashA=QmT78zSuBmuS4z925WZfrqQ1qHaJ56DQaTfyMUF7F8ff5o
+hashB=QmbFMke1KXqnYyBBWxB74N4c5SBnJMVAiMNRcGu6x1AwQH
+
+test_expect_success "entries of the legacy cache are listed" '
+	echo "{\"/ipns/a\": \"/ipfs/$hashA\", \"/ipns/b\": \"/ipfs/$hashB\"}" > "$HOME/.gxcache" &&
+	gx cache ls > ls_out &&
+	test_should_contain "/ipns/a" ls_out &&
+	test_should_contain "/ipns/b" ls_out
+'
+
+test_expect_success "clearing a name keeps the other legacy entries" '
+	gx cache clear /ipns/a &&
+	gx cache ls > ls_out &&
+	test_must_fail grep "/ipns/a" ls_out &&
+	test_should_contain "/ipns/b" ls_out
+'
+
+test_expect_success "clearing everything removes the legacy cache" '
+	gx cache clear &&
+	gx cache ls > ls_out &&
+	test_must_fail grep "/ipns/b" ls_out &&
+	test ! -e "$HOME/.gxcache"
+'
+
+test_expect_success "the legacy cache is not read back after clearing" '
+	echo "{\"/ipns/a\": \"/ipfs/$hashA\"}" > "$HOME/.gxcache" &&
+	gx cache clear &&
+	gx cache ls > ls_out &&
+	test_must_fail grep "/ipns/a" ls_out
+'
+
+test_expect_success "clearing everything waits for the cache lock" '
+	echo "{\"/ipns/a\": \"/ipfs/$hashA\"}" > "$HOME/.gxcache" &&
+	touch cache/gx/resolve.json.lock &&
+	(gx cache clear > clear_out 2>&1; echo $? > clear_exit) &
+	sleep 1 &&
+	test -e "$HOME/.gxcache" &&
+	rm cache/gx/resolve.json.lock &&
+	wait &&
+	echo 0 > exit_exp &&
+	test_cmp exit_exp clear_exit &&
+	test ! -e "$HOME/.gxcache"
+'
+
+test_done
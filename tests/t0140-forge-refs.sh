@@ -0,0 +1,83 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test resolving forge references from local checkouts"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+export GIT_AUTHOR_NAME=gxguy GIT_AUTHOR_EMAIL=gxguy@example.com
+export GIT_COMMITTER_NAME=gxguy GIT_COMMITTER_EMAIL=gxguy@example.com
+
+checkout=src/forge.test/org/a
+
+# release <version> publishes that version of a and commits it
+release() {
+	pkg_run $checkout gx version $1 &&
+	hash=$(publish_package $checkout) &&
+	pkg_run $checkout git add -A &&
+	pkg_run $checkout git commit -q -m $1 &&
+	echo $hash
+}
+
+test_expect_success "setup test packages" '
+	make_package $checkout none &&
+	make_package b none
+'
+
+test_expect_success "resolve forge.test from checkouts only" '
+	echo "{
+		\"resolvers\": {\"forge.test\": {\"raw\": \"http://127.0.0.1:9/{repo}/{ref}/{path}\"}},
+		\"checkouts\": {\"enabled\": true, \"paths\": [\"$(pwd)/src\"]}
+	}" > b/.gxrc
+'
+
+test_expect_success "release versions of a, and a v2 branch" '
+	pkg_run $checkout git init -q &&
+	pkgA100=$(release 1.0.0) &&
+	pkg_run $checkout git tag v1.0.0 &&
+	pkgA110=$(release 1.1.0) &&
+	pkg_run $checkout git tag v1.1.0 &&
+	pkg_run $checkout git checkout -q -b v2 &&
+	pkgA2=$(release 2.0.0-dev) &&
+	pkg_run $checkout git checkout -q master
+'
+
+test_expect_success "a ref like v2 is read as a branch" '
+	pkg_run b gx import forge.test/org/a@v2 &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA2 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_expect_success "a full version is read at its tag" '
+	pkg_run b gx rm a &&
+	pkg_run b gx import forge.test/org/a@1.0.0 &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA100 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_expect_success "a range picks the highest tag in range" '
+	pkg_run b gx rm a &&
+	pkg_run b gx import forge.test/org/a@^1.0.0 &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA110 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_expect_success "a short version that is no branch or tag is a version" '
+	pkg_run b gx rm a &&
+	pkgA3=$(release 3.0.0) &&
+	pkg_run $checkout git tag 3 &&
+	pkg_run b gx import forge.test/org/a@v3 &&
+	jq -r ".gxDependencies[0].hash" b/package.json > hash_out &&
+	echo $pkgA3 > hash_exp &&
+	test_cmp hash_exp hash_out
+'
+
+test_done
@@ -0,0 +1,63 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test layered config files"
+
+. lib/test-lib.sh
+
+test_expect_success "setup test package" '
+	make_package a none &&
+	mkdir -p a/sub
+'
+
+test_expect_success "config files are layered" '
+	echo "{\"user\": {\"name\": \"home\", \"email\": \"home@example.com\"}}" > "$HOME/.gxrc" &&
+	echo "{\"user\": {\"name\": \"pkg\"}}" > a/.gxrc &&
+	(cd a/sub && gx config list --json) > list_out &&
+	jq -r ".[] | select(.key == \"user.name\") | .value" list_out > name_out &&
+	echo pkg > name_exp &&
+	test_cmp name_exp name_out &&
+	jq -r ".[] | select(.key == \"user.email\") | .value" list_out > email_out &&
+	echo home@example.com > email_exp &&
+	test_cmp email_exp email_out
+'
+
+test_expect_success "the environment overrides config files" '
+	(cd a && GX_USER_NAME=env gx config list --json) > list_out &&
+	jq -r ".[] | select(.key == \"user.name\") | .source" list_out > source_out &&
+	echo "\$GX_USER_NAME" > source_exp &&
+	test_cmp source_exp source_out
+'
+
+test_expect_success "unknown keys warn on stderr, keeping json output intact" '
+	echo "{\"user\": {\"name\": \"pkg\"}, \"bogus\": 1}" > a/.gxrc &&
+	(cd a && gx config list --json) > list_out 2> list_err &&
+	jq . list_out > /dev/null &&
+	test_should_contain "warning: .*bogus: unknown config option" list_err
+'
+
+test_expect_success "values of the wrong type are an error" '
+	echo "{\"user\": {\"name\": 5}}" > a/.gxrc &&
+	test_must_fail pkg_run a gx deps > deps_out 2>&1 &&
+	test_should_contain "user.name" deps_out
+'
+
+test_expect_success "config set converts values to the type of the option" '
+	rm a/.gxrc &&
+	pkg_run a gx config set no_verify true &&
+	pkg_run a gx config set repo_priorities.myrepo 500 &&
+	pkg_run a gx config set user.name 42 &&
+	jq -c -S . a/.gxrc > set_out &&
+	echo "{\"no_verify\":true,\"repo_priorities\":{\"myrepo\":500},\"user\":{\"name\":\"42\"}}" > set_exp &&
+	test_cmp set_exp set_out
+'
+
+test_expect_success "config set refuses values that do not convert" '
+	test_must_fail pkg_run a gx config set repo_priorities.myrepo high > set_err 2>&1 &&
+	test_should_contain "repo_priorities.myrepo" set_err
+'
+
+test_done
@@ -0,0 +1,58 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test gxHooks scripts in package.json"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+export MARKS="$(pwd)"
+
+test_expect_success "setup a package with publish scripts" '
+	make_package a test &&
+	cat > hooks.json <<-\EOF &&
+	{
+	  "pre-publish": "echo \"$GX_HOOK $GX_NAME\" > \"$MARKS/pre\"",
+	  "post-publish": {
+	    "run": "echo \"$GX_HOOK $1\" > \"$MARKS/post\"",
+	    "replace": true
+	  }
+	}
+	EOF
+	jq --slurpfile h hooks.json ".gxHooks = \$h[0]" a/package.json > a/package.json.new &&
+	mv a/package.json.new a/package.json
+'
+
+test_expect_success "publishing runs the scripts" '
+	pkgA=$(publish_package a 2> publish_err) &&
+	echo "pre-publish a" > pre_exp &&
+	test_cmp pre_exp pre &&
+	echo "post-publish $pkgA" > post_exp &&
+	test_cmp post_exp post
+'
+
+test_expect_success "replace skips the hook of the subtool" '
+	test_should_contain "HOOK RUN: pre-publish" publish_err &&
+	test_must_fail grep "HOOK RUN: post-publish" publish_err
+'
+
+test_expect_success "gx set keeps the scripts as they were written" '
+	pkg_run a gx set version 0.1.0 &&
+	jq -S -c .gxHooks a/package.json > hooks_out &&
+	jq -S -c . hooks.json > hooks_exp &&
+	test_cmp hooks_exp hooks_out
+'
+
+test_expect_success "a failing script stops the publish" '
+	jq ".gxHooks[\"pre-publish\"] = \"exit 1\"" a/package.json > a/package.json.new &&
+	mv a/package.json.new a/package.json &&
+	rm post &&
+	test_must_fail pkg_run a gx publish &&
+	test ! -e post
+'
+
+test_done
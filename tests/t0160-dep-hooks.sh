@@ -0,0 +1,68 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test gxHooks scripts of dependencies"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+export MARKS="$(pwd)"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b none &&
+	make_package c none &&
+	make_package d none
+'
+
+test_expect_success "a has install and publish scripts" '
+	cat > hooks.json <<-\EOF &&
+	{
+	  "post-install": "touch \"$MARKS/installed\"",
+	  "post-import": "touch \"$MARKS/imported\"",
+	  "pre-publish": "touch \"$MARKS/published\""
+	}
+	EOF
+	jq --slurpfile h hooks.json ".gxHooks = \$h[0]" a/package.json > a/package.json.new &&
+	mv a/package.json.new a/package.json
+'
+
+test_expect_success "scripts of the root package run" '
+	pkgA=$(publish_package a) &&
+	test -f published
+'
+
+test_expect_success "importing a does not run its scripts by default" '
+	pkg_run b gx import $pkgA > import_out &&
+	test ! -e installed &&
+	test ! -e imported &&
+	test_should_contain "not running post-install script of dependency a" import_out &&
+	test_should_contain "not running post-import script of dependency a" import_out
+'
+
+test_expect_success "installing a does not run its scripts by default" '
+	rm -rf b/vendor &&
+	pkg_run b gx install > install_out &&
+	test ! -e installed &&
+	test_should_contain "not running post-install script of dependency a" install_out
+'
+
+test_expect_success "--allow-dep-hooks runs them" '
+	pkg_run c gx --allow-dep-hooks import $pkgA &&
+	test -f installed &&
+	test -f imported
+'
+
+test_expect_success "allow_dep_hooks in .gxrc runs them" '
+	rm installed imported &&
+	echo "{\"allow_dep_hooks\": true}" > d/.gxrc &&
+	pkg_run d gx import $pkgA &&
+	test -f installed &&
+	test -f imported
+'
+
+test_done
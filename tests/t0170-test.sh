@@ -0,0 +1,167 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test running package tests"
+
+. lib/test-lib.sh
+
+export GX_STORE=local
+export GX_STORE_PATH="$(pwd)/store"
+export MARKS="$(pwd)"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b test &&
+	make_package c none
+'
+
+test_expect_success "a has a test command" '
+	jq ".test = \"echo \\\"\$GX_NAME \$*\\\" >> \\\"\$MARKS/a-test\\\"; exit \${1:-0}\"" a/package.json > a/package.json.new &&
+	mv a/package.json.new a/package.json
+'
+
+test_expect_success "gx test runs the test command with its arguments" '
+	pkg_run a gx test 0 -v &&
+	echo "a 0 -v" > a_exp &&
+	test_cmp a_exp a-test
+'
+
+test_expect_success "gx test exits with the exit code of the tests" '
+	test_expect_code 3 pkg_run a gx test 3
+'
+
+test_expect_success "without a test command, the subtool hooks run" '
+	pkg_run b gx test -v 2> b_out &&
+	echo "HOOK RUN: pre-test " > b_exp &&
+	echo "HOOK RUN: test -v" >> b_exp &&
+	echo "HOOK RUN: post-test " >> b_exp &&
+	test_cmp b_exp b_out
+'
+
+test_expect_success "c imports a and b" '
+	pkgA=$(publish_package a) &&
+	pkgB=$(publish_package b 2> /dev/null) &&
+	pkg_run c gx import $pkgA &&
+	pkg_run c gx import $pkgB 2> /dev/null
+'
+
+test_expect_success "gx test --deps runs the tests of every dependency" '
+	rm a-test &&
+	pkg_run c gx test --deps > deps_out 2> deps_err &&
+	echo "a " > a_exp &&
+	test_cmp a_exp a-test &&
+	test_should_contain "HOOK RUN: test" deps_err &&
+	test_should_contain "tests passed for all 2 dependencies" deps_out
+'
+
+test_expect_success "failing dependencies are reported by name" '
+	test_must_fail pkg_run c gx test --deps 4 > deps_out 2>&1 &&
+	test_should_contain "tests of a failed" deps_out &&
+	test_should_contain "tests failed for 1 of 2 dependencies: a" deps_out
+'
+
+test_expect_success "setup a package with a test command and a post-test script" '
+	make_package r none &&
+	mkdir r/sub &&
+	cat > scripts.json <<-\EOF &&
+	{
+	  "test": "pwd > \"$MARKS/r-test\"; exit ${1:-0}",
+	  "gxHooks": {
+	    "post-test": "pwd > \"$MARKS/post-test\""
+	  }
+	}
+	EOF
+	jq -s ".[0] * .[1]" r/package.json scripts.json > r/package.json.new &&
+	mv r/package.json.new r/package.json &&
+	echo "$(pwd)/r" > root_exp
+'
+
+test_expect_success "gx test runs in the package root" '
+	(cd r/sub && gx test) &&
+	test_cmp root_exp r-test &&
+	test_cmp root_exp post-test
+'
+
+test_expect_success "post-test runs when the tests fail" '
+	rm post-test &&
+	test_expect_code 5 pkg_run r/sub gx test 5 &&
+	test_cmp root_exp post-test
+'
+
+test_expect_success "setup a subtool reporting where its hooks run" '
+	mkdir bin &&
+	cat > bin/gx-pwd <<-\EOF &&
+	#!/bin/sh
+	case "$2" in
+	install-path) echo vendor ;;
+	capabilities) ;;
+	*) echo "$2 $(pwd)" >> "$MARKS/hooks" ;;
+	esac
+	EOF
+	chmod +x bin/gx-pwd
+'
+
+export PATH="$(pwd)/bin:$PATH"
+
+test_expect_success "d has a test command, e uses its subtool" '
+	make_package d none &&
+	make_package e pwd &&
+	jq ".test = \"pwd > \\\"\$MARKS/d-test\\\"\"" d/package.json > d/package.json.new &&
+	mv d/package.json.new d/package.json &&
+	pkgD=$(publish_package d) &&
+	pkgE=$(publish_package e) &&
+	make_package f none &&
+	pkg_run f gx import $pkgD &&
+	pkg_run f gx import $pkgE
+'
+
+test_expect_success "gx test --deps runs each test in its dependency" '
+	rm -f hooks &&
+	pkg_run f gx test --deps &&
+	echo "$(pwd)/f/vendor/gx/ipfs/$pkgD/d" > d_exp &&
+	test_cmp d_exp d-test &&
+	dir="$(pwd)/f/vendor/gx/ipfs/$pkgE/e" &&
+	echo "pre-test $dir" > hooks_exp &&
+	echo "test $dir" >> hooks_exp &&
+	echo "post-test $dir" >> hooks_exp &&
+	test_cmp hooks_exp hooks
+'
+
+test_expect_success "missing dependencies are reported by name" '
+	rm -rf f/vendor/gx/ipfs/$pkgE &&
+	test_must_fail pkg_run f gx test --deps > test_out 2>&1 &&
+	test_should_contain "package e ($pkgE) not found" test_out
+'
+
+test_expect_success "g has a pre-test script, h imports it" '
+	make_package g none &&
+	cat > scripts.json <<-\EOF &&
+	{
+	  "test": "true",
+	  "gxHooks": {
+	    "pre-test": "touch \"$MARKS/g-pre-test\""
+	  }
+	}
+	EOF
+	jq -s ".[0] * .[1]" g/package.json scripts.json > g/package.json.new &&
+	mv g/package.json.new g/package.json &&
+	pkgG=$(publish_package g) &&
+	make_package h none &&
+	pkg_run h gx import $pkgG
+'
+
+test_expect_success "gx test --deps skips the scripts of dependencies" '
+	pkg_run h gx test --deps > test_out 2>&1 &&
+	test ! -e g-pre-test &&
+	test_should_contain "not running pre-test script of dependency g" test_out
+'
+
+test_expect_success "gx test --deps runs them with --allow-dep-hooks" '
+	pkg_run h gx --allow-dep-hooks test --deps &&
+	test -e g-pre-test
+'
+
+test_done
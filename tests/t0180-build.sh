@@ -0,0 +1,109 @@
+#!/bin/sh
+#
+# Copyright (c) 2016 Jeromy Johnson
+# MIT Licensed; see the LICENSE file in this repository.
+#
+
+test_description="test gx build and gx run"
+
+. lib/test-lib.sh
+
+export MARKS="$(pwd)"
+
+test_expect_success "setup test packages" '
+	make_package a none &&
+	make_package b test &&
+	cat > scripts.json <<-\EOF &&
+	{
+	  "build": "echo \"$GX_NAME $*\" > \"$MARKS/build\"; exit ${1:-0}",
+	  "scripts": {
+	    "lint": "echo \"$GX_SCRIPT $*\" > \"$MARKS/lint\"; exit ${1:-0}",
+	    "fmt": "true"
+	  }
+	}
+	EOF
+	jq -s ".[0] * .[1]" a/package.json scripts.json > a/package.json.new &&
+	mv a/package.json.new a/package.json
+'
+
+test_expect_success "gx build runs the build command with its arguments" '
+	pkg_run a gx build 0 -v &&
+	echo "a 0 -v" > build_exp &&
+	test_cmp build_exp build
+'
+
+test_expect_success "gx build exits with the exit code of the build" '
+	test_expect_code 4 pkg_run a gx build 4
+'
+
+test_expect_success "without a build command, the subtool hooks run" '
+	pkg_run b gx build -v 2> b_out &&
+	echo "HOOK RUN: pre-build " > b_exp &&
+	echo "HOOK RUN: build -v" >> b_exp &&
+	echo "HOOK RUN: post-build " >> b_exp &&
+	test_cmp b_exp b_out
+'
+
+test_expect_success "setup a build command and a post-build script reporting where they run" '
+	mkdir a/sub &&
+	cat > scripts.json <<-\EOF &&
+	{
+	  "build": "pwd > \"$MARKS/build-dir\"; exit ${1:-0}",
+	  "gxHooks": {
+	    "post-build": "pwd > \"$MARKS/post-build\""
+	  }
+	}
+	EOF
+	jq -s ".[0] * .[1]" a/package.json scripts.json > a/package.json.new &&
+	mv a/package.json.new a/package.json &&
+	echo "$(pwd)/a" > root_exp
+'
+
+test_expect_success "gx build runs in the package root" '
+	(cd a/sub && gx build) &&
+	test_cmp root_exp build-dir &&
+	test_cmp root_exp post-build
+'
+
+test_expect_success "post-build runs when the build fails" '
+	rm post-build &&
+	test_expect_code 4 pkg_run a/sub gx build 4 &&
+	test_cmp root_exp post-build
+'
+
+test_expect_success "gx run lists the scripts" '
+	pkg_run a gx run > list_out &&
+	test_should_contain "^fmt  *true" list_out &&
+	test_should_contain "^lint " list_out
+'
+
+test_expect_success "gx run runs a script with its arguments" '
+	pkg_run a gx run lint 0 -v &&
+	echo "lint 0 -v" > lint_exp &&
+	test_cmp lint_exp lint
+'
+
+test_expect_success "gx run exits with the exit code of the script" '
+	test_expect_code 5 pkg_run a gx run lint 5
+'
+
+test_expect_success "unknown scripts are an error" '
+	test_must_fail pkg_run a gx run nope > run_out 2>&1 &&
+	test_should_contain "no script named \"nope\"" run_out
+'
+
+test_expect_success "gx run runs in the package root" '
+	cat > scripts.json <<-\EOF &&
+	{
+	  "scripts": {
+	    "where": "pwd > \"$MARKS/run-dir\""
+	  }
+	}
+	EOF
+	jq -s ".[0] * .[1]" a/package.json scripts.json > a/package.json.new &&
+	mv a/package.json.new a/package.json &&
+	(cd a/sub && gx run where) &&
+	test_cmp root_exp run-dir
+'
+
+test_done
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"text/tabwriter"
@@ -69,18 +70,22 @@ func genDepsTree(pm *gx.PM, pkg *gx.Package) (*depTreeNode, error) {
 		cur.this = new(gx.Dependency)
 		cur.this.Name = pkg.Name
 
-		err := pkg.ForEachDep(func(dep *gx.Dependency, dpkg *gx.Package) error {
-			sub := complete[dep.Hash]
+		err := pkg.ForEachDep(func(dep *gx.Dependency, hash string, dpkg *gx.Package) error {
+			sub := complete[hash]
 			if sub == nil {
 				var err error
 				sub, err = rec(dpkg)
 				if err != nil {
 					return err
 				}
-				complete[dep.Hash] = sub
+				complete[hash] = sub
 			}
 
 			sub.this = dep
+			if hash != dep.Hash {
+				// show what is actually used
+				sub.this = &gx.Dependency{Name: dep.Name, Hash: hash, Version: dpkg.Version}
+			}
 			cur.children = append(cur.children, sub)
 
 			return nil
@@ -109,6 +114,52 @@ func (dtn *depTreeNode) matches(filter string) bool {
 	return false
 }
 
+// chainsTo returns every chain of dependencies leading from this node to a
+// package matching target, shortest first
+func (dtn *depTreeNode) chainsTo(target string) [][]*gx.Dependency {
+	memo := make(map[*depTreeNode][][]*gx.Dependency)
+
+	var rec func(*depTreeNode) [][]*gx.Dependency
+	rec = func(n *depTreeNode) [][]*gx.Dependency {
+		if chains, ok := memo[n]; ok {
+			return chains
+		}
+
+		var out [][]*gx.Dependency
+		for _, c := range n.children {
+			if c.this.Hash == target || c.this.Name == target {
+				out = append(out, []*gx.Dependency{c.this})
+				continue
+			}
+
+			for _, sub := range rec(c) {
+				chain := append([]*gx.Dependency{c.this}, sub...)
+				out = append(out, chain)
+			}
+		}
+
+		memo[n] = out
+		return out
+	}
+
+	chains := rec(dtn)
+	sort.SliceStable(chains, func(i, j int) bool {
+		if len(chains[i]) != len(chains[j]) {
+			return len(chains[i]) < len(chains[j])
+		}
+		return chainString(chains[i]) < chainString(chains[j])
+	})
+	return chains
+}
+
+func chainString(chain []*gx.Dependency) string {
+	names := make([]string, 0, len(chain))
+	for _, d := range chain {
+		names = append(names, d.Name)
+	}
+	return strings.Join(names, " -> ")
+}
+
 const (
 	tBar  = "│"
 	tEnd  = "└"
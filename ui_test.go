@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	gx "github.com/whyrusleeping/gx/gxutil"
+)
+
+func node(name, hash string, children ...*depTreeNode) *depTreeNode {
+	return &depTreeNode{
+		this:     &gx.Dependency{Name: name, Hash: hash},
+		children: children,
+	}
+}
+
+func chainNames(chains [][]*gx.Dependency) []string {
+	var out []string
+	for _, c := range chains {
+		out = append(out, chainString(c))
+	}
+	return out
+}
+
+func TestChainsTo(t *testing.T) {
+	// root imports b, a and d, a and b both import c, which imports d
+	d := node("d", "QmD")
+	c := node("c", "QmC", d)
+	root := node("root", "", node("b", "QmB", c), node("a", "QmA", c), d)
+
+	cases := []struct {
+		target string
+		chains []string
+	}{
+		{"d", []string{"d", "a -> c -> d", "b -> c -> d"}},
+		{"QmC", []string{"a -> c", "b -> c"}},
+		{"a", []string{"a"}},
+		{"e", nil},
+	}
+
+	for _, cs := range cases {
+		// the order must not depend on map iteration or memoization
+		for i := 0; i < 10; i++ {
+			chains := chainNames(root.chainsTo(cs.target))
+			if !reflect.DeepEqual(chains, cs.chains) {
+				t.Fatalf("%s: expected chains %q, got %q", cs.target, cs.chains, chains)
+			}
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -28,29 +29,36 @@ func RecursiveDepUpdate(pkg *gx.Package, from, to string) error {
 func cascadingUpdate(cur *gx.Package, dir string, updates map[string]string, checked map[string]bool) (bool, error) {
 	log.Log("cascading update of package %s in %s", cur.Name, dir)
 	var changed bool
-	err := cur.ForEachDep(func(dep *gx.Dependency, child *gx.Package) error {
-		if checked[dep.Hash] {
+	err := cur.ForEachDep(func(dep *gx.Dependency, hash string, child *gx.Package) error {
+		if checked[hash] {
 			return nil
 		}
 		log.Log("  - processing %s...", dep.Name)
 
-		if to, ok := updates[dep.Hash]; ok {
+		if to, ok := updates[hash]; ok {
 			log.Log(" ==> updating dep %s on %s", dep.Name, cur.Name)
-			dep.Hash = to
-			dep.Version = child.Version
+			if retarget(cur.Overrides, dep, to) {
+				dep.Version = child.Version
+
+				// the target may be a different version of the package
+				var npkg gx.Package
+				if err := gx.LoadPackage(&npkg, cur.Language, to); err == nil {
+					dep.Version = npkg.Version
+				}
+			}
 			changed = true
 		} else {
-			nchild, err := fetchAndUpdate(dep.Hash, updates, checked)
+			nchild, err := fetchAndUpdate(hash, cur.Overrides, updates, checked)
 			if err != nil {
 				return err
 			}
 
 			if nchild != "" {
-				updates[dep.Hash] = nchild
-				dep.Hash = nchild
+				updates[hash] = nchild
+				retarget(cur.Overrides, dep, nchild)
 				changed = true
 			} else {
-				checked[dep.Hash] = true
+				checked[hash] = true
 			}
 		}
 
@@ -64,7 +72,27 @@ func cascadingUpdate(cur *gx.Package, dir string, updates map[string]string, che
 	return changed, nil
 }
 
-func fetchAndUpdate(tofetch string, updates map[string]string, checked map[string]bool) (string, error) {
+// retarget points dep at hash to. If an override applies to dep, the override
+// is changed instead, as changing dep would have no effect. It returns whether
+// dep itself was changed.
+func retarget(o gx.Overrides, dep *gx.Dependency, to string) bool {
+	if _, ok := o[dep.Hash]; ok {
+		o[dep.Hash] = to
+		return false
+	}
+	if _, ok := o[dep.Name]; ok {
+		o[dep.Name] = to
+		return false
+	}
+	dep.Hash = to
+	return true
+}
+
+// fetchAndUpdate fetches tofetch and cascades updates into it, resolving its
+// dependencies with the given overrides of the root package. It returns the
+// hash of the package republished with its updated dependencies, or "" if
+// none of them changed.
+func fetchAndUpdate(tofetch string, overrides gx.Overrides, updates map[string]string, checked map[string]bool) (string, error) {
 	log.Log("fetch and update: %s", tofetch)
 	dir, err := ioutil.TempDir("", "gx-update")
 	if err != nil {
@@ -78,12 +106,25 @@ func fetchAndUpdate(tofetch string, updates map[string]string, checked map[strin
 		return "", err
 	}
 
-	changed, err := cascadingUpdate(pkg, dir, updates, checked)
+	old := make(map[*gx.Dependency]string)
+	for _, d := range pkg.Dependencies {
+		old[d] = d.Hash
+	}
+
+	// updates of overridden deps go to the root, not into this package
+	own := pkg.Overrides
+	pkg.Overrides = overrides
+	_, err = cascadingUpdate(pkg, dir, updates, checked)
+	pkg.Overrides = own
 	if err != nil {
 		return "", err
 	}
 
-	if changed {
+	for _, d := range pkg.Dependencies {
+		if d.Hash == old[d] {
+			continue
+		}
+
 		err := gx.SavePackageFile(pkg, filepath.Join(dir, pkg.Name, gx.PkgFileName))
 		if err != nil {
 			return "", err
@@ -94,3 +135,131 @@ func fetchAndUpdate(tofetch string, updates map[string]string, checked map[strin
 
 	return "", nil
 }
+
+// updateWithinRange moves every dependency with a version range, or only the
+// named ones, to the newest published version matching its range
+func updateWithinRange(pkg *gx.Package, names []string, ipath string) error {
+	want := make(map[string]bool)
+	for _, n := range names {
+		if pkg.FindDep(n) == nil {
+			return fmt.Errorf("unknown package: %s", n)
+		}
+		want[n] = true
+	}
+
+	type change struct {
+		dep     *gx.Dependency
+		oldhash string
+	}
+
+	var changes []change
+	for _, dep := range pkg.Dependencies {
+		if len(want) > 0 && !want[dep.Name] && !want[dep.Hash] {
+			continue
+		}
+
+		if dep.Range == "" {
+			if len(want) > 0 {
+				return fmt.Errorf("dependency %s has no version range", dep.Name)
+			}
+			continue
+		}
+
+		v, err := pm.ResolveRange(dep.Name, dep.Range)
+		if err != nil {
+			return err
+		}
+
+		if v.Hash == dep.Hash {
+			log.VLog("%s is up to date (%s)", dep.Name, dep.Version)
+			continue
+		}
+
+		npkg, err := pm.InstallPackage(v.Hash, ipath)
+		if err != nil {
+			return fmt.Errorf("(installpackage) : %s", err)
+		}
+
+		if npkg.Name != dep.Name {
+			return fmt.Errorf("%s in range %s resolved to a package named %s", dep.Name, dep.Range, npkg.Name)
+		}
+
+		log.VLog("running pre update hook...")
+		err = gx.TryRunHookOpts("pre-update", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg}, dep.Name)
+		if err != nil {
+			return err
+		}
+
+		log.Log("updating %s to version %s (%s)", dep.Name, npkg.Version, v.Hash)
+		changes = append(changes, change{dep: dep, oldhash: dep.Hash})
+		dep.Hash = v.Hash
+		dep.Version = npkg.Version
+	}
+
+	if len(changes) == 0 {
+		log.Log("all dependencies are up to date")
+		return nil
+	}
+
+	err := gx.SavePackageFile(pkg, PkgFileName)
+	if err != nil {
+		return fmt.Errorf("writing package file: %s", err)
+	}
+
+	for _, ch := range changes {
+		log.VLog("running post update hook...")
+		err := gx.TryRunHookOpts("post-update", pkg.Language, pkg.SubtoolRequired, &gx.HookOptions{Pkg: pkg}, ch.oldhash, ch.dep.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	return refreshLockFile(pkg)
+}
+
+// resolveRangeDeps fills in the hash of dependencies that only specify a
+// version range, reporting whether any were resolved
+func resolveRangeDeps(pkg *gx.Package) (bool, error) {
+	var resolved bool
+	for _, dep := range pkg.Dependencies {
+		if dep.Hash != "" || dep.Range == "" {
+			continue
+		}
+
+		v, err := pm.ResolveRange(dep.Name, dep.Range)
+		if err != nil {
+			return false, err
+		}
+
+		log.Log("resolved %s %s to version %s (%s)", dep.Name, dep.Range, v.Version, v.Hash)
+		dep.Hash = v.Hash
+		dep.Version = v.Version
+		resolved = true
+	}
+
+	return resolved, nil
+}
+
+// refreshLockFile regenerates the lockfile of pkg, if it has one
+func refreshLockFile(pkg *gx.Package) error {
+	root, err := gx.GetPackageRoot()
+	if err != nil {
+		return err
+	}
+
+	lckpath := filepath.Join(root, gx.LckFileName)
+	if _, err := os.Stat(lckpath); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+
+	lck, err := pm.LockForPackage(pkg)
+	if err != nil {
+		return err
+	}
+
+	log.VLog("updating %s", gx.LckFileName)
+	return gx.SaveLockFile(lck, lckpath)
+}